@@ -5,5 +5,5 @@ import (
 )
 
 func main() {
-	tdt.RunCountWARPHits2()
+	tdt.FullConcordance()
 }
@@ -5,5 +5,5 @@ import (
 )
 
 func main() {
-	tdt.RunCountWARPHits3()
+	tdt.FullPermPipeline()
 }
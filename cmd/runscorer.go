@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/jgbaldwinbrown/tdt/pkg"
+)
+
+func main() {
+	tdt.FullRunScorer()
+}
@@ -17,4 +17,7 @@ require (
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa
 )
 
-require github.com/ulikunitz/xz v0.5.12 // indirect
+require (
+	github.com/ulikunitz/xz v0.5.12 // indirect
+	golang.org/x/tools v0.15.0 // indirect
+)
@@ -0,0 +1,256 @@
+package tdt
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+// What to compute while reading background WARP files
+type BackgroundOptions struct {
+	Header     bool
+	TopN       int
+	Chosen     string
+	Candidates []string
+	Score      ScoreFunc     // PosteriorScore if nil
+	Norm       Normalization // NormZ if empty
+	KeepScores bool          // Keep every score, for pooling individuals across files
+}
+
+// Everything the outlier and permlike commands need from one background WARP
+// file. Mean and SD are of the scores of all entries; SD is the population
+// standard deviation, as used by Zscores. Top holds the TopN entries with the
+// largest scores, largest first, and TopZ their normalized scores.
+// ChosenRank is the fraction of entries in the
+// file with a larger score than the chosen individual's, and Candidates holds
+// the same for each candidate found in the file. Scores holds every score in
+// file order, only if KeepScores was set. Entries whose score is not finite
+// are skipped and counted in NSkipped; N counts the rest.
+type BackgroundSummary struct {
+	Path        string
+	N           int
+	NSkipped    int
+	Mean        float64
+	SD          float64
+	Top         []ScoredEntry
+	TopZ        []float64
+	ChosenFound bool
+	ChosenScore float64
+	ChosenRank  float64
+	Candidates  map[string]CandidateScore
+	Scores      []float64
+}
+
+// The score of a candidate individual in one pedigree, and the fraction of
+// the pedigree with a larger score
+type CandidateScore struct {
+	Score float64
+	Rank  float64
+}
+
+// The entry with the largest score
+func (s BackgroundSummary) Max() ScoredEntry {
+	return s.Top[0]
+}
+
+// The normalized score of the entry with the largest score
+func (s BackgroundSummary) MaxZ() float64 {
+	return s.TopZ[0]
+}
+
+// The mean score of the top entries
+func (s BackgroundSummary) TopMean() float64 {
+	sum := 0.0
+	for _, ent := range s.Top {
+		sum += ent.Score
+	}
+	return sum / float64(len(s.Top))
+}
+
+// The mean normalized score of the top entries
+func (s BackgroundSummary) TopZMean() float64 {
+	sum := 0.0
+	for _, z := range s.TopZ {
+		sum += z
+	}
+	return sum / float64(len(s.TopZ))
+}
+
+// Insert ent into top, which is sorted from largest to smallest score and holds at most n entries
+func insertTop(top []ScoredEntry, ent ScoredEntry, n int) []ScoredEntry {
+	if len(top) >= n && ent.Score <= top[len(top)-1].Score {
+		return top
+	}
+	i, _ := slices.BinarySearchFunc(top, ent.Score, func(e ScoredEntry, p float64) int {
+		if e.Score > p {
+			return -1
+		}
+		if e.Score < p {
+			return 1
+		}
+		return 0
+	})
+	for i < len(top) && top[i].Score == ent.Score {
+		i++
+	}
+	top = slices.Insert(top, i, ent)
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Summarize one background WARP file in a single pass. Memory use is bounded
+// by TopN unless KeepScores or Candidates is set or the normalization is not NormZ, except
+// that scores are buffered until the chosen individual, if any, has been seen.
+func SummarizeBackground(path string, opt BackgroundOptions) (BackgroundSummary, error) {
+	s := BackgroundSummary{Path: path}
+	n := max(opt.TopN, 1)
+	score := opt.Score
+	if score == nil {
+		score = PosteriorScore
+	}
+	norm := opt.Norm
+	if norm == "" {
+		norm = NormZ
+	}
+	keep := opt.KeepScores || len(opt.Candidates) > 0 || norm != NormZ
+	candidates := map[string]bool{}
+	for _, id := range opt.Candidates {
+		candidates[id] = true
+	}
+	if len(candidates) > 0 {
+		s.Candidates = map[string]CandidateScore{}
+	}
+	var all []float64
+	var mean, m2 float64
+	var before []float64
+	nhigher := 0
+
+	for ent, e := range ParsePedPath(path, opt.Header) {
+		if e != nil {
+			return s, fmt.Errorf("SummarizeBackground: %w", e)
+		}
+		val := score(ent)
+		if !FiniteScore(val) {
+			s.NSkipped++
+			continue
+		}
+		s.N++
+		d := val - mean
+		mean += d / float64(s.N)
+		m2 += d * (val - mean)
+		s.Top = insertTop(s.Top, ScoredEntry{Entry: ent, Score: val}, n)
+		if keep {
+			all = append(all, val)
+		}
+		if _, seen := s.Candidates[ent.IndividualID]; candidates[ent.IndividualID] && !seen {
+			s.Candidates[ent.IndividualID] = CandidateScore{Score: val}
+		}
+
+		if opt.Chosen == "" {
+			continue
+		}
+		if s.ChosenFound {
+			if val > s.ChosenScore {
+				nhigher++
+			}
+			continue
+		}
+		if ent.IndividualID == opt.Chosen {
+			s.ChosenFound = true
+			s.ChosenScore = val
+			for _, p := range before {
+				if p > s.ChosenScore {
+					nhigher++
+				}
+			}
+			before = nil
+			continue
+		}
+		before = append(before, val)
+	}
+	if s.N < 1 {
+		return s, fmt.Errorf("SummarizeBackground: %v has no entries with a finite score", path)
+	}
+	s.Mean = mean
+	s.SD = math.Sqrt(m2 / float64(s.N))
+	s.ChosenRank = float64(nhigher) / float64(s.N)
+	if opt.KeepScores {
+		s.Scores = all
+	}
+	if len(s.Candidates) > 0 {
+		sorted := slices.Sorted(slices.Values(all))
+		for id, c := range s.Candidates {
+			c.Rank = float64(len(sorted)-upperBound(sorted, c.Score)) / float64(s.N)
+			s.Candidates[id] = c
+		}
+	}
+
+	if norm == NormZ {
+		for _, ent := range s.Top {
+			s.TopZ = append(s.TopZ, standardize(ent.Score, s.Mean, s.SD))
+		}
+		return s, nil
+	}
+	var e error
+	if s.TopZ, e = topNormalized(all, norm, len(s.Top)); e != nil {
+		return s, fmt.Errorf("SummarizeBackground: %v: %w", path, e)
+	}
+	return s, nil
+}
+
+// The number of sorted values less than or equal to x
+func upperBound(sorted []float64, x float64) int {
+	i, _ := slices.BinarySearchFunc(sorted, x, func(v, x float64) int {
+		if v <= x {
+			return -1
+		}
+		return 1
+	})
+	return i
+}
+
+// The total number of entries skipped for non-finite scores in bgs
+func SkippedScores(bgs []BackgroundSummary) int {
+	n := 0
+	for _, bg := range bgs {
+		n += bg.NSkipped
+	}
+	return n
+}
+
+// Summarize every background file on nworkers goroutines, reading each file
+// once. Summaries are returned in the order of paths. If any file fails, the
+// error for the first failing path is returned.
+func SummarizeBackgrounds(paths []string, opt BackgroundOptions, nworkers int) ([]BackgroundSummary, error) {
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	out := make([]BackgroundSummary, len(paths))
+	errs := make([]error, len(paths))
+	jobs := make(chan int, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				out[i], errs[i] = SummarizeBackground(paths[i], opt)
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			return nil, e
+		}
+	}
+	return out, nil
+}
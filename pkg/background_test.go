@@ -0,0 +1,139 @@
+package tdt
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+func TestSummarizeBackgrounds(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(dir, "bg"+string(rune('a'+i))+".txt")
+		// rotate the posteriors so each file differs
+		lines := strings.Split(exampleWarp, "\n")
+		lines = append(lines[i:], lines[:i]...)
+		if e := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); e != nil {
+			t.Fatal(e)
+		}
+		paths = append(paths, path)
+	}
+
+	bgs, e := SummarizeBackgrounds(paths, BackgroundOptions{TopN: 3, Chosen: "4"}, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, bg := range bgs {
+		ents, e := iterh.CollectWithError(ParsePedPath(paths[i], false))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if bg.Path != paths[i] || bg.N != len(ents) {
+			t.Errorf("summary %v: path %v, n %v", i, bg.Path, bg.N)
+		}
+		if want := GetBiggestOutlier(slices.Values(ents)); bg.Max().Score != want.Posterior {
+			t.Errorf("summary %v: max %v != %v", i, bg.Max(), want)
+		}
+		zs, e := GetZscores(ents)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if want := slices.Max(zs); math.Abs(bg.MaxZ()-want) > 1e-9 {
+			t.Errorf("summary %v: max z %v != %v", i, bg.MaxZ(), want)
+		}
+		post, _ := GetIDPosterior("4", slices.Values(ents))
+		rank, _, _ := iterh.Rank(post, Posteriors(slices.Values(ents)))
+		if !bg.ChosenFound || bg.ChosenScore != post || bg.ChosenRank != rank {
+			t.Errorf("summary %v: chosen %v %v %v, expected %v %v", i, bg.ChosenFound, bg.ChosenScore, bg.ChosenRank, post, rank)
+		}
+	}
+
+	lift, e := SummarizeBackground(paths[0], BackgroundOptions{Score: LiftScore})
+	if e != nil {
+		t.Fatal(e)
+	}
+	ents, e := iterh.CollectWithError(ParsePedPath(paths[0], false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := slices.Max(slices.Collect(Scores(slices.Values(ents), LiftScore))); lift.Max().Score != want {
+		t.Errorf("max lift %v != %v", lift.Max().Score, want)
+	}
+}
+
+func TestNonFiniteScores(t *testing.T) {
+	// every geno_risk is numeric except individual 2's; individual 5 has prior 0
+	lines := strings.Split(exampleWarp, "\n")
+	for i := range lines {
+		if i != 1 {
+			lines[i] = strings.Replace(lines[i], "banana", fmt.Sprint(i), 1)
+		}
+	}
+	lines[4] = strings.Replace(lines[4], "\t0.3\t0.2\t", "\t0\t0.2\t", 1)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "warp.txt")
+	if e := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); e != nil {
+		t.Fatal(e)
+	}
+	// a second background in which individual 4 has the largest geno_risk, so
+	// that its rank differs between backgrounds
+	lines[3] = strings.Replace(lines[3], "\t3", "\t100", 1)
+	path2 := filepath.Join(dir, "warp2.txt")
+	if e := os.WriteFile(path2, []byte(strings.Join(lines, "\n")), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	bg, e := SummarizeBackground(path, BackgroundOptions{Score: GenoRiskScore, TopN: 2, KeepScores: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if bg.N != 6 || bg.NSkipped != 1 || math.Abs(bg.Mean-20.0/6) > 1e-12 || bg.Max().Score != 6 || len(bg.Scores) != 6 {
+		t.Errorf("genorisk summary %+v", bg)
+	}
+	lift, e := SummarizeBackground(path, BackgroundOptions{Score: LiftScore})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if lift.N != 6 || lift.NSkipped != 1 || math.IsInf(lift.Max().Score, 0) {
+		t.Errorf("lift summary %+v", lift)
+	}
+
+	real, e := iterh.CollectWithError(ParsePedPath(path, false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	r, e := OutlierStats(real, []string{path, path2}, BackgroundOptions{Score: GenoRiskScore, Chosen: "4"}, TailNone, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r.RealSkipped != 1 || r.BackgroundSkipped != 2 || r.RealOutlier != 6 || r.BackgroundOutlier.Max != 100 || r.Chosen.NBackground != 2 {
+		t.Errorf("outlier report %+v", r)
+	}
+	if e := WriteOutlierJSON(io.Discard, r); e != nil {
+		t.Errorf("report with skipped scores is not valid JSON: %v", e)
+	}
+
+	null, nskipped, e := PermlikeNullDist([]string{path, path2}, NullMax, BackgroundOptions{Score: GenoRiskScore}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p, e := Permlike(real, null, NullMax, GenoRiskScore, []float64{0.5}, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if nskipped != 2 || p.RealSkipped != 1 || p.Observed != 6 || len(p.Individuals) != 6 {
+		t.Errorf("permlike report %+v, %v null skipped", p, nskipped)
+	}
+
+	if _, e := SummarizeBackground(path, BackgroundOptions{Score: func(Entry) float64 { return math.NaN() }}); e == nil {
+		t.Errorf("no error when every score is NaN")
+	}
+}
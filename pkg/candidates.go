@@ -0,0 +1,197 @@
+package tdt
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/jgbaldwinbrown/iterh"
+	"github.com/montanaflynn/stats"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Read candidate individual IDs, one per line. Blank lines, lines starting
+// with '#' and repeated IDs are skipped.
+func ReadCandidatesPath(path string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for line, e := range iterh.PathIter(path, iterh.LineIter) {
+		if e != nil {
+			return nil, fmt.Errorf("ReadCandidatesPath: %w", e)
+		}
+		id := strings.TrimSpace(line)
+		if id == "" || strings.HasPrefix(id, "#") || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// Rank statistics of one candidate individual, as for the chosen individual.
+// NBackground and NMissing count the background pedigrees with and without
+// the candidate. RankShift is the mean background rank minus InternalRank, so
+// it is positive when the candidate ranks higher in the real pedigree than in
+// the backgrounds. Candidates not in the real pedigree have FoundInReal false
+// and are left out of the combined tests.
+type CandidateReport struct {
+	ID              string
+	FoundInReal     bool
+	Score           float64
+	InternalRank    float64
+	Rank            float64
+	NBackground     int
+	NMissing        int
+	BackgroundRanks DistSummary
+	RankShift       float64
+}
+
+// A one-sided Wilcoxon signed-rank test with the normal approximation. W is
+// the sum of the ranks of the positive differences; N excludes zero differences.
+type WilcoxonReport struct {
+	N int
+	W float64
+	Z float64
+	P float64
+}
+
+// Whether a set of candidates ranks higher in the real pedigree than
+// expected. RealMeanRank is the mean internal rank of the candidates found in
+// the real pedigree and at least one background (NUsed of them);
+// BackgroundMeanRanks summarizes the same mean in each background pedigree,
+// and PermutationP is the fraction of backgrounds whose mean is at most the
+// real one, with +1 correction. Wilcoxon tests the candidates' RankShifts
+// against 0.
+type CandidateSetReport struct {
+	NCandidates         int
+	NUsed               int
+	RealMeanRank        float64
+	BackgroundMeanRanks DistSummary
+	PermutationP        float64
+	Wilcoxon            WilcoxonReport
+	Candidates          []CandidateReport
+}
+
+// Test whether the differences ds tend to be positive. With no nonzero
+// differences, Z is 0 and P is 1.
+func WilcoxonSignedRank(ds []float64) WilcoxonReport {
+	var abs []float64
+	var pos []bool
+	for _, d := range ds {
+		if d != 0 && !math.IsNaN(d) {
+			abs = append(abs, math.Abs(d))
+			pos = append(pos, d > 0)
+		}
+	}
+	r := WilcoxonReport{N: len(abs), P: 1}
+	if r.N < 1 {
+		return r
+	}
+	ranks := midRanks(abs)
+	for i, rank := range ranks {
+		if pos[i] {
+			r.W += rank
+		}
+	}
+
+	n := float64(r.N)
+	mean := n * (n + 1) / 4
+	variance := n * (n + 1) * (2*n + 1) / 24
+	counts := map[float64]int{}
+	for _, a := range abs {
+		counts[a]++
+	}
+	for _, t := range counts {
+		variance -= float64(t*t*t-t) / 48
+	}
+	if variance <= 0 {
+		return r
+	}
+	r.Z = (r.W - mean) / math.Sqrt(variance)
+	r.P = distuv.UnitNormal.Survival(r.Z)
+	return r
+}
+
+// Rank statistics for each candidate, and combined tests for the set, from
+// background summaries made with the same candidates and score. Candidates
+// missing from some backgrounds are counted rather than treated as errors.
+func CandidateStats(candidates []string, realEntries []Entry, bgs []BackgroundSummary, score ScoreFunc) (CandidateSetReport, error) {
+	r := CandidateSetReport{NCandidates: len(candidates), Candidates: []CandidateReport{}}
+	realScores := slices.Collect(Scores(slices.Values(realEntries), score))
+	realByID := map[string]float64{}
+	for i, ent := range realEntries {
+		if _, ok := realByID[ent.IndividualID]; !ok {
+			realByID[ent.IndividualID] = realScores[i]
+		}
+	}
+
+	var used []string
+	var shifts, usedRanks []float64
+	for _, id := range candidates {
+		c := CandidateReport{ID: id}
+		var bgScores, bgRanks []float64
+		for _, bg := range bgs {
+			cs, ok := bg.Candidates[id]
+			if !ok {
+				c.NMissing++
+				continue
+			}
+			bgScores = append(bgScores, cs.Score)
+			bgRanks = append(bgRanks, cs.Rank)
+		}
+		c.NBackground = len(bgScores)
+		c.Score, c.FoundInReal = realByID[id]
+		if c.FoundInReal {
+			c.InternalRank, _, _ = iterh.Rank(c.Score, slices.Values(realScores))
+			c.Rank, _, _ = iterh.Rank(c.Score, slices.Values(bgScores))
+		}
+		var e error
+		if c.BackgroundRanks, e = SummarizeDist(bgRanks); e != nil {
+			return r, fmt.Errorf("CandidateStats: %w", e)
+		}
+		if c.FoundInReal && c.NBackground > 0 {
+			c.RankShift = c.BackgroundRanks.Mean - c.InternalRank
+			used = append(used, id)
+			shifts = append(shifts, c.RankShift)
+			usedRanks = append(usedRanks, c.InternalRank)
+		}
+		r.Candidates = append(r.Candidates, c)
+	}
+
+	r.NUsed = len(used)
+	if r.NUsed < 1 {
+		return r, fmt.Errorf("CandidateStats: none of %v candidates are in both the real pedigree and a background pedigree", len(candidates))
+	}
+	var e error
+	if r.RealMeanRank, e = stats.Mean(usedRanks); e != nil {
+		return r, fmt.Errorf("CandidateStats: %w", e)
+	}
+
+	var bgMeans []float64
+	for _, bg := range bgs {
+		sum, n := 0.0, 0
+		for _, id := range used {
+			if cs, ok := bg.Candidates[id]; ok {
+				sum += cs.Rank
+				n++
+			}
+		}
+		if n > 0 {
+			bgMeans = append(bgMeans, sum/float64(n))
+		}
+	}
+	if r.BackgroundMeanRanks, e = SummarizeDist(bgMeans); e != nil {
+		return r, fmt.Errorf("CandidateStats: %w", e)
+	}
+	nlower := 0
+	for _, m := range bgMeans {
+		if m <= r.RealMeanRank {
+			nlower++
+		}
+	}
+	r.PermutationP = float64(nlower+1) / float64(len(bgMeans)+1)
+	r.Wilcoxon = WilcoxonSignedRank(shifts)
+	return r, nil
+}
@@ -0,0 +1,75 @@
+package tdt
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+func TestCandidateStats(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	lines := strings.Split(exampleWarp, "\n")
+	missingID := strings.Split(lines[0], "\t")[1]
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, "bg"+string(rune('a'+i))+".txt")
+		// rotate the individual IDs so each candidate's score changes
+		var rotated []string
+		for j, line := range lines {
+			fields := strings.Split(line, "\t")
+			fields[1] = strings.Split(lines[(j+i)%len(lines)], "\t")[1]
+			// drop the first candidate from one background
+			if i == 4 && fields[1] == missingID {
+				continue
+			}
+			rotated = append(rotated, strings.Join(fields, "\t"))
+		}
+		if e := os.WriteFile(path, []byte(strings.Join(rotated, "\n")), 0644); e != nil {
+			t.Fatal(e)
+		}
+		paths = append(paths, path)
+	}
+
+	realEntries, e := iterh.CollectWithError(ParsePedPath(paths[0], false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	candidates := []string{realEntries[0].IndividualID, realEntries[1].IndividualID, "nobody"}
+
+	bgs, e := SummarizeBackgrounds(paths, BackgroundOptions{Candidates: candidates}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	r, e := CandidateStats(candidates, realEntries, bgs, PosteriorScore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r.NCandidates != 3 || r.NUsed != 2 || len(r.Candidates) != 3 {
+		t.Fatalf("bad counts %+v", r)
+	}
+	for _, c := range r.Candidates[:2] {
+		wantMissing := 0
+		if c.ID == missingID {
+			wantMissing = 1
+		}
+		if !c.FoundInReal || c.NMissing != wantMissing || c.NBackground != 5-wantMissing {
+			t.Errorf("candidate %+v", c)
+		}
+	}
+	if c := r.Candidates[2]; c.FoundInReal || c.NMissing != 5 {
+		t.Errorf("missing candidate %+v", c)
+	}
+	if r.PermutationP <= 0 || r.PermutationP > 1 || r.BackgroundMeanRanks.N != 5 {
+		t.Errorf("permutation test %+v", r)
+	}
+
+	w := WilcoxonSignedRank([]float64{1, 2, 3, -0.5, 0})
+	// ranks of |d| 0.5, 1, 2, 3 are 1..4; positive ones sum to 9
+	if w.N != 4 || w.W != 9 || math.Abs(w.Z-(9-5)/math.Sqrt(7.5)) > 1e-12 {
+		t.Errorf("wilcoxon %+v", w)
+	}
+}
@@ -0,0 +1,346 @@
+package tdt
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/jgbaldwinbrown/iterh"
+	"golang.org/x/exp/rand"
+)
+
+// Distances in steps along parent-child links from individual from to every
+// individual in p, or -1 for individuals not connected to it
+func PedDistances(p *IndexedPed, from int) []int {
+	dist := make([]int, len(p.IDs))
+	for i := range dist {
+		dist[i] = -1
+	}
+	dist[from] = 0
+	queue := []int{from}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		visit := func(j int) {
+			if j != -1 && dist[j] == -1 {
+				dist[j] = dist[i] + 1
+				queue = append(queue, j)
+			}
+		}
+		visit(p.Father[i])
+		visit(p.Mother[i])
+		for _, j := range p.Children[i] {
+			visit(j)
+		}
+	}
+	return dist
+}
+
+// How tightly a set of hits clusters in the pedigree. NClusters is the number
+// of clusters RelativeClustersIndexed makes with the same steps: hits within
+// 2*steps of each other, and so with a relative in common, share a cluster.
+// MeanDistance is the mean distance between pairs of hits connected in the
+// pedigree, or 0 if there are none; NUnconnected counts the other pairs.
+// MeanCloseness is the mean of 1/distance over all pairs, with unconnected
+// pairs counting 0, so unlike MeanDistance it falls as more pairs are
+// unconnected. With more than MaxDistanceSources hits, the pair statistics are
+// estimated from the distances of NSources of them to all the others.
+type ClusterStats struct {
+	NHits         int
+	NClusters     int
+	MeanDistance  float64
+	NUnconnected  int
+	MeanCloseness float64
+	NSources      int
+}
+
+// The largest number of hits whose distances to the other hits
+// HitClusterStats computes; each takes a search of the whole pedigree
+const MaxDistanceSources = 100
+
+// Count the clusters RelativeClustersIndexed makes from hits, with one
+// breadth-first search from all hits at once. Each individual within steps of
+// a hit is claimed by the first hit to reach it, and the hits claiming two
+// linked individuals share a cluster if the link joins them within 2*steps.
+// Takes time proportional to the number of individuals within steps of a hit.
+func countHitClusters(p *IndexedPed, hits []int, steps int) int {
+	owner := make([]int, len(p.IDs)) // 1 + the index in hits of the claiming hit, or 0
+	depth := make([]int, len(p.IDs))
+	set := newDisjointSet(len(hits))
+	queue := make([]int, 0, len(hits))
+	for k, hit := range hits {
+		owner[hit] = k + 1
+		queue = append(queue, hit)
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if depth[i] == steps {
+			continue
+		}
+		visit := func(j int) {
+			if j == -1 {
+				return
+			}
+			if owner[j] == 0 {
+				owner[j] = owner[i]
+				depth[j] = depth[i] + 1
+				queue = append(queue, j)
+				return
+			}
+			if depth[i]+depth[j]+1 <= 2*steps {
+				set.union(owner[i]-1, owner[j]-1)
+			}
+		}
+		visit(p.Father[i])
+		visit(p.Mother[i])
+		for _, j := range p.Children[i] {
+			visit(j)
+		}
+	}
+	n := 0
+	for k := range hits {
+		if set.find(k) == k {
+			n++
+		}
+	}
+	return n
+}
+
+// Compute ClusterStats for the individuals at indices hits. Clusters are
+// counted in time proportional to the part of the pedigree within steps of a
+// hit. The pair statistics take one search of the whole pedigree from each of
+// at most MaxDistanceSources hits, spread evenly through hits.
+func HitClusterStats(p *IndexedPed, hits []int, steps int) ClusterStats {
+	s := ClusterStats{NHits: len(hits), NClusters: countHitClusters(p, hits, steps)}
+	if len(hits) < 2 {
+		return s
+	}
+	s.NSources = min(len(hits), MaxDistanceSources)
+	sum, nconnected, nunconnected := 0, 0, 0
+	closeness := 0.0
+	for k := 0; k < s.NSources; k++ {
+		from := hits[k*len(hits)/s.NSources]
+		dist := PedDistances(p, from)
+		for _, hit := range hits {
+			switch d := dist[hit]; {
+			case hit == from:
+			case d == -1:
+				nunconnected++
+			default:
+				sum += d
+				nconnected++
+				closeness += 1 / float64(d)
+			}
+		}
+	}
+	if nconnected > 0 {
+		s.MeanDistance = float64(sum) / float64(nconnected)
+	}
+	// each source is paired with every other hit, so scale to the number of unordered pairs
+	npairs := len(hits) * (len(hits) - 1) / 2
+	s.NUnconnected = int(math.Round(float64(nunconnected) * float64(npairs) / float64(nconnected+nunconnected)))
+	s.MeanCloseness = closeness / float64(nconnected+nunconnected)
+	return s
+}
+
+// Whether hits cluster more than expected. The null is either "relabel",
+// random sets of the same number of individuals from the same pedigree, or
+// "background", the same number of top-scoring individuals from each
+// background pedigree, so every null replicate has as many hits as the
+// observed set. ClustersP is the fraction of null replicates with at most the
+// observed number of clusters, and ClosenessP the fraction with at least the
+// observed mean closeness, both with +1 correction.
+type ClusterTest struct {
+	Observed      ClusterStats
+	Null          string
+	NReplicates   int
+	NullClusters  DistSummary
+	NullCloseness DistSummary
+	ClustersP     float64
+	ClosenessP    float64
+}
+
+// Compare observed cluster statistics to null replicates
+func NewClusterTest(observed ClusterStats, null string, nullStats []ClusterStats) (ClusterTest, error) {
+	h := csvh.Handle1[ClusterTest]("NewClusterTest: %w")
+	t := ClusterTest{Observed: observed, Null: null, NReplicates: len(nullStats)}
+	var clusters, closeness []float64
+	nclusters, ncloseness := 0, 0
+	for _, s := range nullStats {
+		clusters = append(clusters, float64(s.NClusters))
+		closeness = append(closeness, s.MeanCloseness)
+		if s.NClusters <= observed.NClusters {
+			nclusters++
+		}
+		if s.MeanCloseness >= observed.MeanCloseness {
+			ncloseness++
+		}
+	}
+	var e error
+	if t.NullClusters, e = SummarizeDist(clusters); e != nil {
+		return h(e)
+	}
+	if t.NullCloseness, e = SummarizeDist(closeness); e != nil {
+		return h(e)
+	}
+	t.ClustersP = float64(nclusters+1) / float64(len(nullStats)+1)
+	t.ClosenessP = float64(ncloseness+1) / float64(len(nullStats)+1)
+	return t, nil
+}
+
+// Test in.Hits against nreps random sets of the same number of individuals
+// from in.Listed. Replicate i is seeded by ReplicateSeed(seed, i), so the
+// result does not depend on nworkers.
+func RelabelClusterTest(in ClusterInput, steps int, seed uint64, nreps, nworkers int) (ClusterTest, error) {
+	nullStats := make([]ClusterStats, nreps)
+	RunReplicates(seed, nreps, nworkers,
+		func() struct{} { return struct{}{} },
+		func(_ struct{}, i int, src rand.Source) {
+			perm := MathRand(src).Perm(len(in.Listed))
+			hits := make([]int, len(in.Hits))
+			for j := range hits {
+				hits[j] = in.Listed[perm[j]]
+			}
+			nullStats[i] = HitClusterStats(in.Ped, hits, steps)
+		},
+		func(struct{}, struct{}) {},
+	)
+	return NewClusterTest(HitClusterStats(in.Ped, in.Hits, steps), "relabel", nullStats)
+}
+
+// The pedigree of one WARP output file, ready to cluster. Ped includes a
+// placeholder for every parent named but not listed in the file, as
+// WithParentPlaceholders adds. Listed holds the indices of the individuals
+// listed in the file, and Hits those with a finite score of at least the
+// threshold, in file order.
+type ClusterInput struct {
+	Ped    *IndexedPed
+	Listed []int
+	Hits   []int
+}
+
+// The entries of one WARP output file, their indexed pedigree with parent
+// placeholders, and the indices of the listed individuals in file order
+func readClusterPed(path string, header bool) ([]Entry, *IndexedPed, []int, error) {
+	ents, e := iterh.CollectWithError(ParsePedPath(path, header))
+	if e != nil {
+		return nil, nil, nil, e
+	}
+	p := NewIndexedPed(WithParentPlaceholders(BuildPedTree(slices.Collect(ToPedEntries(slices.Values(ents)))...)))
+	var listed []int
+	seen := map[int]bool{}
+	for _, ent := range ents {
+		if i := p.Index[ent.IndividualID]; !seen[i] {
+			seen[i] = true
+			listed = append(listed, i)
+		}
+	}
+	return ents, p, listed, nil
+}
+
+// Read one WARP output file for clustering, with hits the individuals with a
+// finite score of at least thresh
+func ReadClusterInput(path string, header bool, thresh float64, score ScoreFunc) (ClusterInput, error) {
+	ents, p, listed, e := readClusterPed(path, header)
+	if e != nil {
+		return ClusterInput{}, e
+	}
+	in := ClusterInput{Ped: p, Listed: listed}
+	seen := map[int]bool{}
+	for _, ent := range ents {
+		i := p.Index[ent.IndividualID]
+		if v := score(ent); FiniteScore(v) && v >= thresh && !seen[i] {
+			seen[i] = true
+			in.Hits = append(in.Hits, i)
+		}
+	}
+	return in, nil
+}
+
+// The indexed pedigree of one WARP output file, with parent placeholders, and
+// the indices of the individuals with a finite score, from the highest score
+// to the lowest. Ties
+// keep file order, and an individual listed twice is ranked by its higher score.
+func ReadRankedClusterInput(path string, header bool, score ScoreFunc) (*IndexedPed, []int, error) {
+	ents, p, _, e := readClusterPed(path, header)
+	if e != nil {
+		return nil, nil, e
+	}
+	best := map[int]float64{}
+	var ranked []int
+	for _, ent := range ents {
+		v := score(ent)
+		if !FiniteScore(v) {
+			continue
+		}
+		i := p.Index[ent.IndividualID]
+		if old, ok := best[i]; !ok {
+			ranked = append(ranked, i)
+			best[i] = v
+		} else if v > old {
+			best[i] = v
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b int) int {
+		return cmp.Compare(best[b], best[a])
+	})
+	return p, ranked, nil
+}
+
+// Compute, for each hit count in nhits, the cluster statistics of that many
+// top-scoring individuals in each background WARP file, on nworkers
+// goroutines. Each background file is read once. A background with fewer
+// scored individuals than a hit count contributes all of them.
+func BackgroundClusterStats(bgPaths []string, header bool, score ScoreFunc, steps int, nhits []int, nworkers int) (map[int][]ClusterStats, error) {
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	out := map[int][]ClusterStats{}
+	for _, n := range nhits {
+		out[n] = make([]ClusterStats, len(bgPaths))
+	}
+	errs := make([]error, len(bgPaths))
+	jobs := make(chan int, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p, ranked, e := ReadRankedClusterInput(bgPaths[i], header, score)
+				if e != nil {
+					errs[i] = fmt.Errorf("BackgroundClusterStats: %v: %w", bgPaths[i], e)
+					continue
+				}
+				for n, stats := range out {
+					stats[i] = HitClusterStats(p, ranked[:min(n, len(ranked))], steps)
+				}
+			}
+		}()
+	}
+	for i := range bgPaths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			return nil, e
+		}
+	}
+	return out, nil
+}
+
+// Describe a cluster test in one line
+func (t ClusterTest) String() string {
+	return fmt.Sprintf("clustering of %v hits: %v clusters (null mean %v, p %v); mean closeness %v over all pairs (null mean %v, p %v); mean distance %v between %v connected pairs; null from %v %v replicates",
+		t.Observed.NHits, t.Observed.NClusters, t.NullClusters.Mean, t.ClustersP,
+		t.Observed.MeanCloseness, t.NullCloseness.Mean, t.ClosenessP,
+		t.Observed.MeanDistance, t.Observed.NHits*(t.Observed.NHits-1)/2-t.Observed.NUnconnected,
+		t.NReplicates, t.Null)
+}
@@ -0,0 +1,423 @@
+package tdt
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/jgbaldwinbrown/iterh"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// One individual found in both the TDT results and the WARP output. Ranks
+// start at 1 for the most significant TDT P and the highest WARP score, with
+// ties given their mean rank. ConsensusRank orders individuals by the mean of
+// the two ranks. Disagree is "tdt" or "warp" if the individual is in the top
+// DisagreeK of only that method, and empty otherwise.
+type ConcordanceEntry struct {
+	FamilyID      string
+	IndividualID  string
+	TDTP          float64
+	Chisq         float64
+	WarpScore     float64
+	TDTRank       float64
+	WarpRank      float64
+	MeanRank      float64
+	ConsensusRank int
+	RankDiff      float64
+	Disagree      string
+}
+
+// A rank correlation and its two-sided p-value. With fewer than 3
+// individuals, or with all ranks tied, the correlation is 0 and P is 1.
+type RankCorrelation struct {
+	R float64
+	P float64
+}
+
+// The overlap of the top K individuals of each method. Ties at the cutoff
+// are broken by individual ID. Expected is the overlap of two random sets of
+// K, K*K/N.
+type TopKOverlap struct {
+	K        int
+	Overlap  int
+	Jaccard  float64
+	Expected float64
+}
+
+// Agreement between TDT results and WARP scores for the same pedigree.
+// NTDT and NWarp count the distinct individuals of each input, and NJoined
+// the individuals in both, which are the only ones compared.
+type ConcordanceReport struct {
+	Score       string
+	NTDT        int
+	NWarp       int
+	NJoined     int
+	Spearman    RankCorrelation
+	Kendall     RankCorrelation
+	TopK        []TopKOverlap
+	DisagreeK   int
+	NDisagree   int
+	Individuals []ConcordanceEntry
+}
+
+// Spearman's correlation of two sets of ranks, the Pearson correlation of
+// the ranks, with the p-value of its t statistic on n-2 degrees of freedom
+func SpearmanRanks(xs, ys []float64) RankCorrelation {
+	out := RankCorrelation{P: 1}
+	n := len(xs)
+	if n < 3 || len(ys) != n {
+		return out
+	}
+	mx, my := 0.0, 0.0
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(n)
+	my /= float64(n)
+	sxy, sxx, syy := 0.0, 0.0, 0.0
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return out
+	}
+	out.R = sxy / math.Sqrt(sxx*syy)
+	if math.Abs(out.R) >= 1 {
+		out.P = 0
+		return out
+	}
+	t := out.R * math.Sqrt(float64(n-2)/(1-out.R*out.R))
+	out.P = 2 * distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(n - 2)}.Survival(math.Abs(t))
+	return out
+}
+
+// Kendall's tau-b of two sets of ranks, with the p-value of the normal
+// approximation to tau under no ties. Takes time quadratic in the number of
+// individuals.
+func KendallTauB(xs, ys []float64) RankCorrelation {
+	out := RankCorrelation{P: 1}
+	n := len(xs)
+	if n < 3 || len(ys) != n {
+		return out
+	}
+	var concordant, discordant, tiesX, tiesY float64
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			dx := cmp.Compare(xs[i], xs[j])
+			dy := cmp.Compare(ys[i], ys[j])
+			switch {
+			case dx == 0 && dy == 0:
+			case dx == 0:
+				tiesX++
+			case dy == 0:
+				tiesY++
+			case dx == dy:
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+	denom := math.Sqrt((concordant + discordant + tiesX) * (concordant + discordant + tiesY))
+	if denom == 0 {
+		return out
+	}
+	out.R = (concordant - discordant) / denom
+	nf := float64(n)
+	z := 3 * out.R * math.Sqrt(nf*(nf-1)) / math.Sqrt(2*(2*nf+5))
+	out.P = 2 * distuv.UnitNormal.Survival(math.Abs(z))
+	return out
+}
+
+// Indices of the k best-ranked individuals, breaking ties by individual ID
+func topKByRank(ents []ConcordanceEntry, rank func(ConcordanceEntry) float64, k int) map[int]bool {
+	order := make([]int, len(ents))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if c := cmp.Compare(rank(ents[a]), rank(ents[b])); c != 0 {
+			return c
+		}
+		return cmp.Compare(ents[a].IndividualID, ents[b].IndividualID)
+	})
+	out := map[int]bool{}
+	for _, i := range order[:min(k, len(order))] {
+		out[i] = true
+	}
+	return out
+}
+
+func tdtRank(ent ConcordanceEntry) float64  { return ent.TDTRank }
+func warpRank(ent ConcordanceEntry) float64 { return ent.WarpRank }
+
+// Compare TDT results to WARP entries, joining TDT result names to WARP
+// individual IDs. The first record of a repeated ID is used. Missing TDT P
+// values rank last, as do missing or infinite WARP scores. The top-k overlap is reported
+// for each of ks, and individuals in only one method's top disagreeK are
+// flagged. Individuals are listed in consensus order.
+func Concordance(results []TDTResult, ents []Entry, score ScoreFunc, ks []int, disagreeK int) (ConcordanceReport, error) {
+	tdtByID := map[string]TDTResult{}
+	for _, res := range results {
+		if _, ok := tdtByID[res.Name]; !ok {
+			tdtByID[res.Name] = res
+		}
+	}
+	r := ConcordanceReport{NTDT: len(tdtByID), DisagreeK: disagreeK, TopK: []TopKOverlap{}, Individuals: []ConcordanceEntry{}}
+
+	seen := map[string]bool{}
+	for _, ent := range ents {
+		if seen[ent.IndividualID] {
+			continue
+		}
+		seen[ent.IndividualID] = true
+		res, ok := tdtByID[ent.IndividualID]
+		if !ok {
+			continue
+		}
+		r.Individuals = append(r.Individuals, ConcordanceEntry{
+			FamilyID:     ent.FamilyID,
+			IndividualID: ent.IndividualID,
+			TDTP:         res.P,
+			Chisq:        res.Chisq,
+			WarpScore:    score(ent),
+		})
+	}
+	r.NWarp = len(seen)
+	r.NJoined = len(r.Individuals)
+	if r.NJoined < 1 {
+		return r, fmt.Errorf("Concordance: none of %v TDT results match the %v WARP individuals", r.NTDT, r.NWarp)
+	}
+
+	ps := make([]float64, r.NJoined)
+	negScores := make([]float64, r.NJoined)
+	for i, ind := range r.Individuals {
+		ps[i] = ind.TDTP
+		if math.IsNaN(ps[i]) {
+			ps[i] = math.Inf(1)
+		}
+		negScores[i] = -ind.WarpScore
+		if !FiniteScore(negScores[i]) {
+			negScores[i] = math.Inf(1)
+		}
+	}
+	tdtRanks := midRanks(ps)
+	warpRanks := midRanks(negScores)
+	for i := range r.Individuals {
+		ind := &r.Individuals[i]
+		ind.TDTRank = tdtRanks[i]
+		ind.WarpRank = warpRanks[i]
+		ind.MeanRank = (ind.TDTRank + ind.WarpRank) / 2
+		ind.RankDiff = ind.WarpRank - ind.TDTRank
+	}
+	r.Spearman = SpearmanRanks(tdtRanks, warpRanks)
+	r.Kendall = KendallTauB(tdtRanks, warpRanks)
+
+	for _, k := range ks {
+		tdtTop := topKByRank(r.Individuals, tdtRank, k)
+		warpTop := topKByRank(r.Individuals, warpRank, k)
+		o := TopKOverlap{K: k}
+		for i := range tdtTop {
+			if warpTop[i] {
+				o.Overlap++
+			}
+		}
+		if union := len(tdtTop) + len(warpTop) - o.Overlap; union > 0 {
+			o.Jaccard = float64(o.Overlap) / float64(union)
+		}
+		o.Expected = float64(len(tdtTop)) * float64(len(warpTop)) / float64(r.NJoined)
+		r.TopK = append(r.TopK, o)
+	}
+
+	if disagreeK > 0 {
+		tdtTop := topKByRank(r.Individuals, tdtRank, disagreeK)
+		warpTop := topKByRank(r.Individuals, warpRank, disagreeK)
+		for i := range r.Individuals {
+			switch {
+			case tdtTop[i] && !warpTop[i]:
+				r.Individuals[i].Disagree = "tdt"
+			case warpTop[i] && !tdtTop[i]:
+				r.Individuals[i].Disagree = "warp"
+			default:
+				continue
+			}
+			r.NDisagree++
+		}
+	}
+
+	slices.SortStableFunc(r.Individuals, func(a, b ConcordanceEntry) int {
+		if c := cmp.Compare(a.MeanRank, b.MeanRank); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(min(a.TDTRank, a.WarpRank), min(b.TDTRank, b.WarpRank)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.IndividualID, b.IndividualID)
+	})
+	for i := range r.Individuals {
+		r.Individuals[i].ConsensusRank = i + 1
+	}
+	return r, nil
+}
+
+// Write the summary statistics, then one line per individual in consensus order
+func WriteConcordanceText(w io.Writer, r ConcordanceReport) error {
+	if _, e := fmt.Fprintf(w, "joined %v of %v TDT results and %v WARP individuals by %v\n", r.NJoined, r.NTDT, r.NWarp, r.Score); e != nil {
+		return e
+	}
+	if _, e := fmt.Fprintf(w, "spearman %v (p %v); kendall tau-b %v (p %v)\n", r.Spearman.R, r.Spearman.P, r.Kendall.R, r.Kendall.P); e != nil {
+		return e
+	}
+	for _, o := range r.TopK {
+		if _, e := fmt.Fprintf(w, "top %v: overlap %v (expected %v); jaccard %v\n", o.K, o.Overlap, o.Expected, o.Jaccard); e != nil {
+			return e
+		}
+	}
+	if r.DisagreeK > 0 {
+		if _, e := fmt.Fprintf(w, "%v individuals in only one top %v\n", r.NDisagree, r.DisagreeK); e != nil {
+			return e
+		}
+	}
+	for _, ind := range r.Individuals {
+		if _, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", ind.ConsensusRank, ind.FamilyID, ind.IndividualID, ind.TDTP, ind.WarpScore, ind.TDTRank, ind.WarpRank, ind.Disagree); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Write one line per individual in consensus order
+func WriteConcordanceTSV(w io.Writer, r ConcordanceReport) error {
+	cw := csvh.CsvOut(w)
+	if e := cw.Write([]string{"ConsensusRank", "FamilyID", "IndividualID", "TDTP", "Chisq", "WarpScore", "TDTRank", "WarpRank", "MeanRank", "RankDiff", "Disagree"}); e != nil {
+		return e
+	}
+	for _, ind := range r.Individuals {
+		if e := cw.Write([]string{
+			strconv.Itoa(ind.ConsensusRank),
+			ind.FamilyID,
+			ind.IndividualID,
+			fmt.Sprint(ind.TDTP),
+			fmt.Sprint(ind.Chisq),
+			fmt.Sprint(ind.WarpScore),
+			fmt.Sprint(ind.TDTRank),
+			fmt.Sprint(ind.WarpRank),
+			fmt.Sprint(ind.MeanRank),
+			fmt.Sprint(ind.RankDiff),
+			ind.Disagree,
+		}); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write a concordance report in format "text", "json" or "tsv". TDT P values
+// and scores that are not finite are written as strings in JSON.
+func WriteConcordanceReport(w io.Writer, format string, r ConcordanceReport) error {
+	switch format {
+	case "text":
+		return WriteConcordanceText(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(concordanceJson(r))
+	case "tsv":
+		return WriteConcordanceTSV(w, r)
+	default:
+		return fmt.Errorf("WriteConcordanceReport: unknown format %q", format)
+	}
+}
+
+// A ConcordanceEntry whose floats may not be finite, for JSON
+type concordanceEntryJson struct {
+	ConcordanceEntry
+	TDTP      any
+	Chisq     any
+	WarpScore any
+}
+
+type concordanceReportJson struct {
+	ConcordanceReport
+	Individuals []concordanceEntryJson
+}
+
+func concordanceJson(r ConcordanceReport) concordanceReportJson {
+	out := concordanceReportJson{ConcordanceReport: r, Individuals: make([]concordanceEntryJson, 0, len(r.Individuals))}
+	for _, ind := range r.Individuals {
+		out.Individuals = append(out.Individuals, concordanceEntryJson{
+			ConcordanceEntry: ind,
+			TDTP:             FloatToJson(ind.TDTP),
+			Chisq:            FloatToJson(ind.Chisq),
+			WarpScore:        FloatToJson(ind.WarpScore),
+		})
+	}
+	return out
+}
+
+// Flags for FullConcordance
+type ConcordanceFlags struct {
+	TDTPath    string
+	WarpPath   string
+	WarpHeader bool
+	Score      string
+	Ks         string
+	DisagreeK  int
+	Format     string
+	OutPath    string
+}
+
+// Run on the command line
+func FullConcordance() {
+	var f ConcordanceFlags
+	flag.StringVar(&f.TDTPath, "t", "", "Path to tdtall JSON output")
+	flag.StringVar(&f.WarpPath, "r", "", "Path to output of warp for the same pedigree")
+	flag.BoolVar(&f.WarpHeader, "rh", false, "WARP output has a header line")
+	flag.StringVar(&f.Score, "score", "posterior", "WARP column or derived score to rank individuals by: "+ScoreFuncNames())
+	flag.StringVar(&f.Ks, "k", "10,50", "Comma-separated sizes of the top sets to compare")
+	flag.IntVar(&f.DisagreeK, "d", 10, "Flag individuals in the top d of only one method (0 to disable)")
+	flag.StringVar(&f.Format, "f", "text", "Output format: text, json or tsv")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write output (default stdout)")
+	flag.Parse()
+	if f.TDTPath == "" {
+		log.Fatal(fmt.Errorf("missing -t"))
+	}
+	if f.WarpPath == "" {
+		log.Fatal(fmt.Errorf("missing -r"))
+	}
+	score, e := ParseScoreFunc(f.Score)
+	Must(e)
+	kfs, e := ParseFloatList(f.Ks)
+	Must(e)
+	var ks []int
+	for _, k := range kfs {
+		if k < 1 || k != math.Trunc(k) {
+			log.Fatal(fmt.Errorf("top set size %v is not a positive integer", k))
+		}
+		ks = append(ks, int(k))
+	}
+
+	results, e := ReadPathResults(f.TDTPath)
+	Must(e)
+	ents, e := iterh.CollectWithError(ParsePedPath(f.WarpPath, f.WarpHeader))
+	Must(e)
+	report, e := Concordance(results, ents, score, ks, f.DisagreeK)
+	Must(e)
+	report.Score = f.Score
+	Must(WriteOutput(f.OutPath, func(w io.Writer) error {
+		return WriteConcordanceReport(w, f.Format, report)
+	}))
+}
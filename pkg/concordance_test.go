@@ -0,0 +1,73 @@
+package tdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestConcordance(t *testing.T) {
+	var results []TDTResult
+	var ents []Entry
+	// TDT ranks a..e in order; WARP agrees except that it swaps b and d, and
+	// f is only in the WARP output
+	ids := []string{"a", "b", "c", "d", "e"}
+	posteriors := map[string]float64{"a": 0.9, "b": 0.3, "c": 0.5, "d": 0.7, "e": 0.1, "f": 1}
+	for i, id := range ids {
+		results = append(results, TDTResult{Name: id, P: float64(i+1) / 100})
+	}
+	results = append(results, TDTResult{Name: "nan", P: math.NaN()})
+	for _, id := range append(ids, "f") {
+		ents = append(ents, Entry{FamilyID: "fam", IndividualID: id, Posterior: posteriors[id]})
+	}
+
+	r, e := Concordance(results, ents, PosteriorScore, []int{2, 10}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r.NTDT != 6 || r.NWarp != 6 || r.NJoined != 5 {
+		t.Fatalf("bad counts %+v", r)
+	}
+	// rank differences 0, 2, 0, -2, 0: Spearman 1 - 6*8/(5*24)
+	if math.Abs(r.Spearman.R-0.6) > 1e-12 {
+		t.Errorf("spearman %+v", r.Spearman)
+	}
+	// b, c and d are in reverse order: 7 concordant and 3 discordant pairs
+	if math.Abs(r.Kendall.R-0.4) > 1e-12 {
+		t.Errorf("kendall %+v", r.Kendall)
+	}
+	if o := r.TopK[0]; o.Overlap != 1 || o.Jaccard != 1.0/3 || o.Expected != 0.8 {
+		t.Errorf("top 2 %+v", o)
+	}
+	if o := r.TopK[1]; o.K != 10 || o.Overlap != 5 || o.Jaccard != 1 {
+		t.Errorf("top 10 %+v", o)
+	}
+
+	var order []string
+	flags := map[string]string{}
+	for _, ind := range r.Individuals {
+		order = append(order, ind.IndividualID)
+		flags[ind.IndividualID] = ind.Disagree
+	}
+	// mean ranks: a 1, b 3, c 3, d 3, e 5; among ties, b and d reach rank 2
+	if want := []string{"a", "b", "d", "c", "e"}; !slices.Equal(order, want) {
+		t.Errorf("consensus order %v, want %v", order, want)
+	}
+	if r.NDisagree != 2 || flags["b"] != "tdt" || flags["d"] != "warp" || flags["a"] != "" {
+		t.Errorf("disagreements %v: %v", r.NDisagree, flags)
+	}
+
+	var buf bytes.Buffer
+	if e := WriteConcordanceReport(&buf, "json", r); e != nil {
+		t.Fatal(e)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("invalid json %s", buf.Bytes())
+	}
+
+	if _, e := Concordance(results, []Entry{{IndividualID: "nobody"}}, PosteriorScore, nil, 0); e == nil {
+		t.Errorf("no error for disjoint inputs")
+	}
+}
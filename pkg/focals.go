@@ -1,5 +1,10 @@
 package tdt
 
+import (
+	"cmp"
+	"slices"
+)
+
 // Check if p has a father in tree
 func HasFather(p PedEntry, tree map[string]Node) bool {
 	_, ok := tree[p.PaternalID]
@@ -51,3 +56,14 @@ func UniqPed(ped ...PedEntry) []PedEntry {
 	}
 	return out
 }
+
+// Remove duplicates from the pedigree and sort it by IndividualID. Unlike
+// UniqPed, the order does not depend on map iteration, so a seeded shuffle of
+// the result is reproducible.
+func SortedUniqPed(ped ...PedEntry) []PedEntry {
+	out := UniqPed(ped...)
+	slices.SortFunc(out, func(a, b PedEntry) int {
+		return cmp.Compare(a.IndividualID, b.IndividualID)
+	})
+	return out
+}
@@ -0,0 +1,207 @@
+package tdt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/jgbaldwinbrown/iterh"
+	xrand "golang.org/x/exp/rand"
+)
+
+// One shuffled replicate. Path is the shuffled .ped file; Output, when set, is
+// where the WARP output for that .ped file is (or will be) written.
+type ManifestReplicate struct {
+	Replicate int
+	Seed      uint64 `json:",string"`
+	Path      string
+	Output    string `json:",omitempty"`
+}
+
+// A record of how a set of shuffled pedigrees was made. Each replicate's seed
+// is ReplicateSeed(Seed, Replicate), so any replicate can be regenerated from
+// the input file alone.
+type ReplicateManifest struct {
+	Input       string
+	InputSHA256 string
+	Seed        uint64 `json:",string"`
+	Mode        string
+	ShufPhenos  bool
+	Replicates  []ManifestReplicate
+}
+
+// The path to record as a manifest's Input: "-" when the input was read
+// from stdin
+func ManifestInput(path string) string {
+	if path == "" {
+		return "-"
+	}
+	return path
+}
+
+// Check that format, a path for each replicate as given to pedshufsex -wo,
+// has exactly one %d for the replicate number and no other verbs
+func ValidateReplicateFormat(format string) error {
+	nd := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		switch {
+		case i < len(format) && format[i] == '%':
+		case i < len(format) && format[i] == 'd':
+			nd++
+		default:
+			return fmt.Errorf("replicate path format %q has a verb other than %%d", format)
+		}
+	}
+	if nd != 1 {
+		return fmt.Errorf("replicate path format %q has %v %%d verbs, not 1", format, nd)
+	}
+	return nil
+}
+
+// Compute the SHA-256 checksum of a file's raw bytes
+func FileSHA256(path string) (string, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, e := io.Copy(h, f); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Parse a .ped file, also returning the SHA-256 checksum of its raw bytes. An empty path reads stdin.
+func ParsePedPathHash(path string) ([]PedEntry, string, error) {
+	if path != "" {
+		sum, e := FileSHA256(path)
+		if e != nil {
+			return nil, "", e
+		}
+		ps, e := ParsePedPathMaybe(path)
+		return ps, sum, e
+	}
+
+	b, e := io.ReadAll(os.Stdin)
+	if e != nil {
+		return nil, "", e
+	}
+	sum := sha256.Sum256(b)
+	ps, e := ParsePedFromReader(bytes.NewReader(b))
+	return ps, hex.EncodeToString(sum[:]), e
+}
+
+// Shuffle a copy of ps with the random stream of one replicate
+func ShufReplicate(ps []PedEntry, seed uint64, mode ShufMode, shufPhenos bool) []PedEntry {
+	out := slices.Clone(ps)
+	r := MathRand(xrand.NewSource(seed))
+	if shufPhenos {
+		ShufPedPheno(out, r)
+	} else {
+		ShufPedSexMode(out, r, mode)
+	}
+	return out
+}
+
+// Write a manifest as indented JSON
+func WriteManifestPath(path string, m ReplicateManifest) (err error) {
+	w, e := csvh.CreateMaybeGz(path)
+	if e != nil {
+		return e
+	}
+	defer func() { csvh.DeferE(&err, w.Close()) }()
+	bw := bufio.NewWriter(w)
+	defer func() { csvh.DeferE(&err, bw.Flush()) }()
+
+	enc := json.NewEncoder(bw)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
+
+// Read a manifest written by WriteManifestPath
+func ReadManifestPath(path string) (ReplicateManifest, error) {
+	var m ReplicateManifest
+	r, e := csvh.OpenMaybeGz(path)
+	if e != nil {
+		return m, e
+	}
+	defer r.Close()
+	if e := json.NewDecoder(r).Decode(&m); e != nil {
+		return m, fmt.Errorf("ReadManifestPath: %v: %w", path, e)
+	}
+	return m, nil
+}
+
+// The WARP output path of every replicate, in replicate order
+func (m ReplicateManifest) OutputPaths() ([]string, error) {
+	out := make([]string, 0, len(m.Replicates))
+	for _, rep := range m.Replicates {
+		if rep.Output == "" {
+			return nil, fmt.Errorf("OutputPaths: replicate %v (%v) has no WARP output path", rep.Replicate, rep.Path)
+		}
+		out = append(out, rep.Output)
+	}
+	return out, nil
+}
+
+// Check whether a file starts with a JSON object, as a manifest does
+func IsManifestPath(path string) (bool, error) {
+	r, e := csvh.OpenMaybeGz(path)
+	if e != nil {
+		return false, e
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	for {
+		b, e := br.ReadByte()
+		if e == io.EOF {
+			return false, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		if !bytes.ContainsRune([]byte(" \t\r\n"), rune(b)) {
+			return b == '{', nil
+		}
+	}
+}
+
+// Read background WARP output paths from either a replicate manifest or a
+// plain list with one path per line
+func BackgroundPaths(path string) ([]string, error) {
+	isManifest, e := IsManifestPath(path)
+	if e != nil {
+		return nil, e
+	}
+	if isManifest {
+		m, e := ReadManifestPath(path)
+		if e != nil {
+			return nil, e
+		}
+		return m.OutputPaths()
+	}
+
+	var out []string
+	for line, e := range iterh.PathIter(path, iterh.LineIter) {
+		if e != nil {
+			return nil, e
+		}
+		if strings.TrimSpace(line) != "" {
+			out = append(out, line)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package tdt
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBackgroundPathsManifest(t *testing.T) {
+	dir := t.TempDir()
+	ps := SortedUniqPed(scanExamplePed()...)
+	m := ReplicateManifest{Seed: 7, Mode: string(ShufSibship)}
+	for i := 0; i < 3; i++ {
+		m.Replicates = append(m.Replicates, ManifestReplicate{
+			Replicate: i,
+			Seed:      ReplicateSeed(m.Seed, i),
+			Path:      filepath.Join(dir, "rep.ped"),
+			Output:    filepath.Join(dir, "rep.warp"),
+		})
+	}
+	mpath := filepath.Join(dir, "manifest.json")
+	if e := WriteManifestPath(mpath, m); e != nil {
+		t.Fatal(e)
+	}
+
+	m2, e := ReadManifestPath(mpath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !slices.Equal(m2.Replicates, m.Replicates) {
+		t.Errorf("%v != %v", m2.Replicates, m.Replicates)
+	}
+	a := ShufReplicate(ps, m2.Replicates[1].Seed, ShufMode(m2.Mode), m2.ShufPhenos)
+	b := ShufReplicate(ps, m.Replicates[1].Seed, ShufSibship, false)
+	if !slices.Equal(a, b) {
+		t.Errorf("regenerated replicate differs")
+	}
+
+	paths, e := BackgroundPaths(mpath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(paths) != 3 || paths[0] != m.Replicates[0].Output {
+		t.Errorf("manifest paths %v", paths)
+	}
+
+	lpath := filepath.Join(dir, "paths.txt")
+	if e := os.WriteFile(lpath, []byte("a.warp\nb.warp\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	paths, e = BackgroundPaths(lpath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !slices.Equal(paths, []string{"a.warp", "b.warp"}) {
+		t.Errorf("list paths %v", paths)
+	}
+}
+
+func TestValidateReplicateFormat(t *testing.T) {
+	for _, format := range []string{"shuf_%d.warp", "%d", "100%%_%d.txt"} {
+		if e := ValidateReplicateFormat(format); e != nil {
+			t.Errorf("%q: %v", format, e)
+		}
+	}
+	for _, format := range []string{"shuf.warp", "shuf_%d_%d.warp", "shuf_%s.warp", "shuf_%v.warp", "shuf_%d%"} {
+		if e := ValidateReplicateFormat(format); e == nil {
+			t.Errorf("%q accepted", format)
+		}
+	}
+}
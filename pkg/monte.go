@@ -1,6 +1,7 @@
 package tdt
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,13 +12,15 @@ import (
 	"io"
 	"log"
 	"math"
+	mrand "math/rand"
+	"os"
 )
 
-// Take a set of counts of total offspring in an extended family and make a new set of families with binomially-drawn offspring with P(male) = 0.5
-func Perm1(r rand.Source, totals []float64) []Family {
+// Take a set of counts of total offspring in an extended family and make a new set of families with binomially-drawn offspring with P(male) = pmale
+func Perm1(r rand.Source, totals []float64, pmale float64) []Family {
 	out := make([]Family, 0, len(totals))
 	for _, tot := range totals {
-		b := distuv.Binomial{N: tot, P: 0.5, Src: r}
+		b := distuv.Binomial{N: tot, P: pmale, Src: r}
 		males := b.Rand()
 		females := tot - males
 		out = append(out, Family{males, females})
@@ -26,28 +29,77 @@ func Perm1(r rand.Source, totals []float64) []Family {
 }
 
 // Run Perm1 repeatedly
-func Perm(r rand.Source, nperms int, totals []float64) [][]Family {
+func Perm(r rand.Source, nperms int, totals []float64, pmale float64) [][]Family {
 	out := make([][]Family, 0, nperms)
 	for i := 0; i < nperms; i++ {
-		out = append(out, Perm1(r, totals))
+		out = append(out, Perm1(r, totals, pmale))
 	}
 	return out
 }
 
-// Run the TDT test on each of fams independently
-func TDTMultipleFamilies(fams []Family) []TDTResult {
+// Redraw the sex of every offspring that is not itself a parent, making it
+// male with probability pmale. Parents keep the sex their parental role
+// requires, so the topology of the real pedigree is preserved. Like
+// PermuteLeafSex, it takes a math/rand generator; wrap a replicate's source
+// with MathRand.
+func GeneDropSex(r *mrand.Rand, pmale float64) PedNull {
+	return func(p *IndexedPed) *IndexedPed {
+		sex := slices.Clone(p.Sex)
+		for i := range p.IDs {
+			if !p.IsOffspring(i) || len(p.Children[i]) > 0 {
+				continue
+			}
+			if r.Float64() < pmale {
+				sex[i] = 1
+			} else {
+				sex[i] = 2
+			}
+		}
+		return p.WithSex(sex)
+	}
+}
+
+// Drop genes through the real pedigree once and run the full Y lineage TDT scan on the replicate
+func GeneDropReplicate(r *mrand.Rand, x ExpectedRatio, p *IndexedPed) []TDTResult {
+	null := GeneDropSex(r, x.Overall(p))
+	return NoZeroes(TDTScan(null(p), LineageY, x))
+}
+
+// Simulate every background lineage once, drawing its offspring binomially
+// as Perm1 does. Each simulated lineage keeps the name and number of fathers
+// of the lineage it was drawn from. Like Perm1, this takes a
+// golang.org/x/exp/rand source because the gonum distributions need one.
+func SimulateLineages1(r rand.Source, bg []TDTResult, pmale float64) []TDTResult {
+	tots := make([]float64, 0, len(bg))
+	for _, bg1 := range bg {
+		tots = append(tots, bg1.Totals.MaleF1+bg1.Totals.FemaleF1)
+	}
+	set := TDTMultipleFamilies(Perm1(r, tots, pmale), pmale)
+	for i := range set {
+		res := &set[i]
+		res.Name = bg[i].Name
+		res.Nfamilies = bg[i].Nfamilies
+		res.MeanMalesPerFam = res.Totals.MaleF1 / res.Nfamilies
+		res.MeanFemalesPerFam = res.Totals.FemaleF1 / res.Nfamilies
+		res.MeanChildrenPerFam = (res.Totals.MaleF1 + res.Totals.FemaleF1) / res.Nfamilies
+	}
+	return set
+}
+
+// Run the TDT test on each of fams independently, expecting a male fraction of pmale
+func TDTMultipleFamilies(fams []Family, pmale float64) []TDTResult {
 	out := make([]TDTResult, 0, len(fams))
 	for _, fam := range fams {
-		out = append(out, TDTTest(fam))
+		out = append(out, TDTTestRatio(pmale, fam))
 	}
 	return out
 }
 
 // Run TDTMultipleFamilies on each set in famsets
-func TDTReplicateFamilySets(famsets [][]Family) [][]TDTResult {
+func TDTReplicateFamilySets(famsets [][]Family, pmale float64) [][]TDTResult {
 	out := make([][]TDTResult, 0, len(famsets))
 	for _, famset := range famsets {
-		out = append(out, TDTMultipleFamilies(famset))
+		out = append(out, TDTMultipleFamilies(famset, pmale))
 	}
 	return out
 }
@@ -138,6 +190,14 @@ type MonteArgs struct {
 	Background string
 	Seed       int
 	Replicates int
+	Null       string
+	Ped        string
+	Expected   ExpectedRatio
+	Thresholds string
+	Format     string
+	OutPath    string
+	Workers    int
+	MinMatched int
 }
 
 // Remove zeroes, infs, and nans from results
@@ -165,55 +225,121 @@ func FullMonte() {
 	flag.StringVar(&f.Background, "b", "", "path to .json containing background families")
 	flag.IntVar(&f.Seed, "s", 0, "Random seed")
 	flag.IntVar(&f.Replicates, "r", 1, "Replicates")
+	flag.StringVar(&f.Null, "n", "binomial", "Null model: binomial (draw background family totals independently) or genedrop (redraw offspring sexes in the real pedigree)")
+	flag.StringVar(&f.Ped, "p", "", "path to the real .ped file (required for -n genedrop)")
+	RegisterExpectedRatioFlags(&f.Expected)
+	flag.StringVar(&f.Thresholds, "t", "0.05,0.01,0.001,0.0001", "Comma-separated background percentiles to report top fractions for")
+	flag.StringVar(&f.Format, "f", "json", "Output format: json or tsv")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write output (default stdout)")
+	ReplicateWorkersFlag(&f.Workers)
+	flag.IntVar(&f.MinMatched, "mm", DefaultMinMatched, "Mark records whose size stratum has fewer null lineages than this as MatchedSparse; strata are binned from the pooled null, not simulated separately")
 	flag.Parse()
 	if f.Actual == "" {
 		log.Fatal(fmt.Errorf("missing -a"))
 	}
-	if f.Background == "" {
-		log.Fatal(fmt.Errorf("missing -b"))
+	if e := f.Expected.Validate(); e != nil {
+		log.Fatal(e)
 	}
-
-	actualSlice, e := ReadPathResults(f.Actual)
+	if f.Expected.FromPed && f.Null != "genedrop" {
+		log.Fatal(fmt.Errorf("-mp requires -n genedrop, which has a pedigree to estimate from"))
+	}
+	if f.Format != "json" && f.Format != "tsv" {
+		log.Fatal(fmt.Errorf("unknown format %q", f.Format))
+	}
+	thresholds, e := ParseFloatList(f.Thresholds)
 	if e != nil {
 		log.Fatal(e)
 	}
-	actual := actualSlice[0]
 
-	bg, e := ReadPathResults(f.Background)
+	actualSlice, e := ReadPathResults(f.Actual)
 	if e != nil {
 		log.Fatal(e)
 	}
-	bg = NoZeroes(bg)
+	actuals := NoZeroes(actualSlice)
+	if skipped := len(actualSlice) - len(actuals); skipped > 0 {
+		log.Printf("skipping %v actual lineages with no offspring or no P value", skipped)
+	}
 
-	tots := make([]float64, 0, len(bg))
-	for _, bg1 := range bg {
-		tots = append(tots, float64(bg1.Totals.MaleF1+bg1.Totals.FemaleF1))
-	}
-
-	rsrc := rand.NewSource(uint64(f.Seed))
-	perms := Perm(rsrc, f.Replicates, tots)
-
-	results := TDTReplicateFamilySets(perms)
-	perc := MostSignificantPercentage(actual, results)
-	fmt.Println(perc)
-	perc2 := TopSignificantPercentage(0.05, actual, results)
-	fmt.Println(perc2)
-	perc3 := TopSignificantPercentage(0.01, actual, results)
-	fmt.Println(perc3)
-	perc4 := TopSignificantPercentage(0.001, actual, results)
-	fmt.Println(perc4)
-	perc5 := TopSignificantPercentage(0.0001, actual, results)
-	fmt.Println(perc5)
-
-	better := 0
-	count := 0
-	for _, set := range results {
-		for _, res := range set {
-			if actual.P < res.P {
-				better++
-			}
-			count++
+	var replicate func(src rand.Source) []TDTResult
+	switch f.Null {
+	case "binomial":
+		if f.Background == "" {
+			log.Fatal(fmt.Errorf("missing -b"))
+		}
+		bg, e := ReadPathResults(f.Background)
+		if e != nil {
+			log.Fatal(e)
+		}
+		bg = NoZeroes(bg)
+
+		replicate = func(src rand.Source) []TDTResult {
+			return SimulateLineages1(src, bg, f.Expected.MaleProb)
 		}
+	case "genedrop":
+		if f.Ped == "" {
+			log.Fatal(fmt.Errorf("missing -p"))
+		}
+		r, e := csvh.OpenMaybeGz(f.Ped)
+		if e != nil {
+			log.Fatal(e)
+		}
+		peds, e := ParsePedSafe(r)
+		r.Close()
+		if e != nil {
+			log.Fatal(e)
+		}
+		p := NewIndexedPed(BuildPedTree(peds...))
+		replicate = func(src rand.Source) []TDTResult {
+			return GeneDropReplicate(MathRand(src), f.Expected, p)
+		}
+	default:
+		log.Fatal(fmt.Errorf("unknown null %q", f.Null))
+	}
+
+	acc := RunReplicates(uint64(f.Seed), f.Replicates, f.Workers,
+		func() *MonteAccumulator {
+			acc := NewMonteAccumulator(actuals, thresholds)
+			acc.MinMatched = f.MinMatched
+			return acc
+		},
+		func(acc *MonteAccumulator, i int, src rand.Source) {
+			acc.Add(replicate(src))
+		},
+		(*MonteAccumulator).Merge,
+	)
+	recs := acc.Records()
+	nsparse := 0
+	for _, rec := range recs {
+		if rec.MatchedSparse {
+			nsparse++
+		}
+	}
+	if nsparse > 0 {
+		log.Printf("%v of %v lineages have fewer than %v null lineages in their size stratum; their MatchedEmpiricalP is unreliable", nsparse, len(recs), f.MinMatched)
+	}
+
+	var w io.Writer = os.Stdout
+	if f.OutPath != "" {
+		ww, e := csvh.CreateMaybeGz(f.OutPath)
+		if e != nil {
+			log.Fatal(e)
+		}
+		defer func() {
+			Must(ww.Close())
+		}()
+		w = ww
+	}
+	bw := bufio.NewWriter(w)
+	defer func() {
+		Must(bw.Flush())
+	}()
+	switch f.Format {
+	case "json":
+		e = WriteMonteJSON(bw, recs...)
+	case "tsv":
+		e = WriteMonteTSV(bw, thresholds, recs...)
+	}
+	if e != nil {
+		log.Fatal(e)
 	}
-	fmt.Println(float64(better) / float64(count))
 }
@@ -0,0 +1,270 @@
+package tdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jgbaldwinbrown/csvh"
+)
+
+// Parse a comma-separated list of floats, such as "0.05,0.01"
+func ParseFloatList(s string) ([]float64, error) {
+	var out []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		f, e := strconv.ParseFloat(field, 64)
+		if e != nil {
+			return nil, fmt.Errorf("ParseFloatList: %w", e)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// The P values of each background replicate, sorted from most to least significant
+func SortedPs(background [][]TDTResult) [][]float64 {
+	out := make([][]float64, 0, len(background))
+	for _, set := range background {
+		ps := make([]float64, 0, len(set))
+		for _, res := range set {
+			ps = append(ps, res.P)
+		}
+		slices.Sort(ps)
+		out = append(out, ps)
+	}
+	return out
+}
+
+// The fraction of background replicates in which the actual lineage is above the Threshold percentile
+type TopFraction struct {
+	Threshold float64
+	Fraction  float64
+}
+
+// The comparison of one actual lineage to all simulated backgrounds
+type MonteRecord struct {
+	Name                string
+	P                   float64
+	MostSignificantFrac float64
+	TopFractions        []TopFraction
+	NullAsSignificant   int
+	NullCount           int
+	EmpiricalP          float64
+
+	Stratum                  SizeStratum
+	MatchedNullAsSignificant int
+	MatchedNullCount         int
+	MatchedEmpiricalP        float64
+	MatchedSparse            bool
+}
+
+// The default smallest number of null lineages in a stratum for
+// MatchedEmpiricalP to be trusted. Matched counts come from binning the
+// ordinary null replicates by size afterwards; replicates are not generated
+// per stratum, so rare sizes get few matched lineages.
+const DefaultMinMatched = 100
+
+// Running counts for comparing actual lineages to null replicates. Replicates
+// are added one at a time and then thrown away, so memory use does not grow
+// with the number of replicates. Records with fewer than MinMatched null
+// lineages in their stratum are marked MatchedSparse.
+type MonteAccumulator struct {
+	Actuals              []TDTResult
+	Thresholds           []float64
+	MinMatched           int
+	Replicates           int
+	MostSignificant      []int
+	Top                  [][]int
+	AsSignificant        []int
+	Count                []int
+	MatchedAsSignificant []int
+	MatchedCount         []int
+}
+
+func NewMonteAccumulator(actuals []TDTResult, thresholds []float64) *MonteAccumulator {
+	a := &MonteAccumulator{
+		Actuals:              actuals,
+		Thresholds:           thresholds,
+		MinMatched:           DefaultMinMatched,
+		MostSignificant:      make([]int, len(actuals)),
+		Top:                  make([][]int, len(actuals)),
+		AsSignificant:        make([]int, len(actuals)),
+		Count:                make([]int, len(actuals)),
+		MatchedAsSignificant: make([]int, len(actuals)),
+		MatchedCount:         make([]int, len(actuals)),
+	}
+	for i := range a.Top {
+		a.Top[i] = make([]int, len(thresholds))
+	}
+	return a
+}
+
+// Count the sorted P values that are at most p
+func countAtMost(sorted []float64, p float64) int {
+	return sort.Search(len(sorted), func(i int) bool {
+		return sorted[i] > p
+	})
+}
+
+// Compare actual number i to one replicate's sorted P values
+func (a *MonteAccumulator) addSorted(i int, ps []float64) {
+	actual := a.Actuals[i]
+	if len(ps) < 1 || actual.P <= ps[0] {
+		a.MostSignificant[i]++
+	}
+	for j, t := range a.Thresholds {
+		idx := int(float64(len(ps)) * t)
+		if idx < len(ps) && actual.P < ps[idx] {
+			a.Top[i][j]++
+		}
+	}
+	a.AsSignificant[i] += countAtMost(ps, actual.P)
+	a.Count[i] += len(ps)
+}
+
+// Add one null replicate
+func (a *MonteAccumulator) Add(replicate []TDTResult) {
+	ps := SortedPs([][]TDTResult{replicate})[0]
+	strata := StratifiedPs([][]TDTResult{replicate})
+	for i, actual := range a.Actuals {
+		a.addSorted(i, ps)
+		sps := strata[StratumOf(actual)]
+		a.MatchedAsSignificant[i] += countAtMost(sps, actual.P)
+		a.MatchedCount[i] += len(sps)
+	}
+	a.Replicates++
+}
+
+// Add the counts in b to a. Both must have the same actuals and thresholds.
+func (a *MonteAccumulator) Merge(b *MonteAccumulator) {
+	a.Replicates += b.Replicates
+	for i := range a.Actuals {
+		a.MostSignificant[i] += b.MostSignificant[i]
+		for j := range a.Thresholds {
+			a.Top[i][j] += b.Top[i][j]
+		}
+		a.AsSignificant[i] += b.AsSignificant[i]
+		a.Count[i] += b.Count[i]
+		a.MatchedAsSignificant[i] += b.MatchedAsSignificant[i]
+		a.MatchedCount[i] += b.MatchedCount[i]
+	}
+}
+
+// Summarize the counts as one record per actual lineage. Empirical p-values
+// are the rank of the actual lineage among the null lineages, with +1
+// correction. A matched p-value from few null lineages is close to 1 however
+// extreme the lineage is, so such records are marked MatchedSparse.
+func (a *MonteAccumulator) Records() []MonteRecord {
+	out := make([]MonteRecord, 0, len(a.Actuals))
+	nreps := float64(a.Replicates)
+	for i, actual := range a.Actuals {
+		rec := MonteRecord{
+			Name:                     actual.Name,
+			P:                        actual.P,
+			MostSignificantFrac:      float64(a.MostSignificant[i]) / nreps,
+			NullAsSignificant:        a.AsSignificant[i],
+			NullCount:                a.Count[i],
+			EmpiricalP:               float64(a.AsSignificant[i]+1) / float64(a.Count[i]+1),
+			Stratum:                  StratumOf(actual),
+			MatchedNullAsSignificant: a.MatchedAsSignificant[i],
+			MatchedNullCount:         a.MatchedCount[i],
+			MatchedEmpiricalP:        float64(a.MatchedAsSignificant[i]+1) / float64(a.MatchedCount[i]+1),
+			MatchedSparse:            a.MatchedCount[i] < a.MinMatched,
+		}
+		for j, t := range a.Thresholds {
+			rec.TopFractions = append(rec.TopFractions, TopFraction{Threshold: t, Fraction: float64(a.Top[i][j]) / nreps})
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// A band of lineage sizes. Lineages fall in the same stratum when their
+// numbers of fathers and of offspring have the same power-of-two magnitude,
+// so a lineage with 5 fathers and 40 offspring is matched with lineages of 4-7
+// fathers and 32-63 offspring.
+type SizeStratum struct {
+	FathersLog2   int
+	OffspringLog2 int
+}
+
+func floorLog2(x float64) int {
+	if x < 1 {
+		return -1
+	}
+	return int(math.Floor(math.Log2(x)))
+}
+
+// Find the size stratum of a lineage
+func StratumOf(r TDTResult) SizeStratum {
+	return SizeStratum{
+		FathersLog2:   floorLog2(r.Nfamilies),
+		OffspringLog2: floorLog2(r.Totals.MaleF1 + r.Totals.FemaleF1),
+	}
+}
+
+// Pool the P values of all background lineages by size stratum, sorted from most to least significant
+func StratifiedPs(background [][]TDTResult) map[SizeStratum][]float64 {
+	out := map[SizeStratum][]float64{}
+	for _, set := range background {
+		for _, res := range set {
+			s := StratumOf(res)
+			out[s] = append(out[s], res.P)
+		}
+	}
+	for _, ps := range out {
+		slices.Sort(ps)
+	}
+	return out
+}
+
+// Write one JSON object per record
+func WriteMonteJSON(w io.Writer, recs ...MonteRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	for _, rec := range recs {
+		if e := enc.Encode(rec); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Write a TSV table with one line per record. Every record must use the same thresholds.
+func WriteMonteTSV(w io.Writer, thresholds []float64, recs ...MonteRecord) error {
+	cw := csvh.CsvOut(w)
+	header := []string{"Name", "P", "MostSignificantFrac"}
+	for _, t := range thresholds {
+		header = append(header, fmt.Sprintf("Top%vFrac", t))
+	}
+	header = append(header, "NullAsSignificant", "NullCount", "EmpiricalP",
+		"FathersLog2", "OffspringLog2", "MatchedNullAsSignificant", "MatchedNullCount", "MatchedEmpiricalP", "MatchedSparse")
+	if e := cw.Write(header); e != nil {
+		return e
+	}
+
+	var line []string
+	for _, rec := range recs {
+		line = append(line[:0], rec.Name, fmt.Sprint(rec.P), fmt.Sprint(rec.MostSignificantFrac))
+		for _, tf := range rec.TopFractions {
+			line = append(line, fmt.Sprint(tf.Fraction))
+		}
+		line = append(line, fmt.Sprint(rec.NullAsSignificant), fmt.Sprint(rec.NullCount), fmt.Sprint(rec.EmpiricalP),
+			fmt.Sprint(rec.Stratum.FathersLog2), fmt.Sprint(rec.Stratum.OffspringLog2),
+			fmt.Sprint(rec.MatchedNullAsSignificant), fmt.Sprint(rec.MatchedNullCount), fmt.Sprint(rec.MatchedEmpiricalP), fmt.Sprint(rec.MatchedSparse))
+		if e := cw.Write(line); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,150 @@
+package tdt
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/rand"
+)
+
+func TestMonteAccumulator(t *testing.T) {
+	bg := [][]TDTResult{
+		[]TDTResult{{P: 0.5}, {P: 0.01}, {P: 0.2}, {P: 0.9}},
+		[]TDTResult{{P: 0.3}, {P: 0.4}, {P: 0.6}, {P: 0.7}},
+	}
+	acc := NewMonteAccumulator([]TDTResult{{Name: "a", P: 0.1}}, []float64{0.25})
+	for _, rep := range bg {
+		acc.Add(rep)
+	}
+	rec := acc.Records()[0]
+	if rec.MostSignificantFrac != 0.5 {
+		t.Errorf("MostSignificantFrac %v != 0.5", rec.MostSignificantFrac)
+	}
+	if rec.TopFractions[0].Fraction != 1 {
+		t.Errorf("top fraction %v != 1", rec.TopFractions[0].Fraction)
+	}
+	if rec.NullAsSignificant != 1 || rec.NullCount != 8 {
+		t.Errorf("null counts %v / %v", rec.NullAsSignificant, rec.NullCount)
+	}
+	if rec.EmpiricalP != 2.0/9.0 {
+		t.Errorf("EmpiricalP %v != 2/9", rec.EmpiricalP)
+	}
+}
+
+func TestRunReplicatesWorkers(t *testing.T) {
+	bg := []TDTResult{
+		{Totals: Family{5, 5}, Nfamilies: 2},
+		{Totals: Family{20, 12}, Nfamilies: 4},
+		{Totals: Family{1, 3}, Nfamilies: 1},
+	}
+	actuals := []TDTResult{{Name: "a", P: 0.05, Totals: Family{15, 5}, Nfamilies: 3}}
+	run := func(nworkers int) []MonteRecord {
+		acc := RunReplicates(7, 200, nworkers,
+			func() *MonteAccumulator {
+				return NewMonteAccumulator(actuals, []float64{0.05})
+			},
+			func(acc *MonteAccumulator, i int, src rand.Source) {
+				acc.Add(SimulateLineages1(src, bg, 0.5))
+			},
+			(*MonteAccumulator).Merge,
+		)
+		return acc.Records()
+	}
+	one := run(1)
+	many := run(5)
+	if !reflect.DeepEqual(one, many) {
+		t.Errorf("1 worker %#v != 5 workers %#v", one, many)
+	}
+	if one[0].NullCount != 600 {
+		t.Errorf("NullCount %v != 600", one[0].NullCount)
+	}
+}
+
+func TestGeneDropSex(t *testing.T) {
+	// 5 is a father, so it must stay male; 6..12 are leaf offspring
+	ps := append(scanExamplePed(), PedEntry{"1", "13", "5", "9", 2, 1})
+	p := NewIndexedPed(BuildPedTree(ps...))
+	for _, pmale := range []float64{0, 1} {
+		q := GeneDropSex(MathRand(rand.NewSource(3)), pmale)(p)
+		for i, id := range q.IDs {
+			leaf := p.IsOffspring(i) && len(p.Children[i]) == 0
+			switch {
+			case !leaf && q.Sex[i] != p.Sex[i]:
+				t.Errorf("pmale %v: non-leaf %v changed sex", pmale, id)
+			case leaf && pmale == 1 && q.Sex[i] != 1:
+				t.Errorf("pmale 1: leaf %v is not male", id)
+			case leaf && pmale == 0 && q.Sex[i] != 2:
+				t.Errorf("pmale 0: leaf %v is not female", id)
+			}
+		}
+		if !reflect.DeepEqual(q.Father, p.Father) || !reflect.DeepEqual(q.Mother, p.Mother) {
+			t.Errorf("pmale %v: topology changed", pmale)
+		}
+	}
+	if p.Sex[p.Index["13"]] != 2 {
+		t.Errorf("GeneDropSex modified the input pedigree")
+	}
+}
+
+func TestGeneDropReplicateWorkers(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	actuals := NoZeroes(TDTScan(p, LineageY, ExpectedRatio{MaleProb: 0.5}))
+	run := func(nworkers int) []MonteRecord {
+		acc := RunReplicates(11, 100, nworkers,
+			func() *MonteAccumulator {
+				return NewMonteAccumulator(actuals, []float64{0.05})
+			},
+			func(acc *MonteAccumulator, i int, src rand.Source) {
+				acc.Add(GeneDropReplicate(MathRand(src), ExpectedRatio{FromPed: true}, p))
+			},
+			(*MonteAccumulator).Merge,
+		)
+		return acc.Records()
+	}
+	one := run(1)
+	if many := run(4); !reflect.DeepEqual(one, many) {
+		t.Errorf("1 worker %#v != 4 workers %#v", one, many)
+	}
+	if len(one) != len(actuals) || one[0].NullCount == 0 {
+		t.Errorf("records %#v", one)
+	}
+}
+
+func TestMatchedCounts(t *testing.T) {
+	small := TDTResult{Name: "small", P: 0.01, Totals: Family{4, 3}, Nfamilies: 1}
+	big := TDTResult{Name: "big", P: 0.01, Totals: Family{30, 10}, Nfamilies: 5}
+	if s := StratumOf(small); s != (SizeStratum{0, 2}) {
+		t.Errorf("small stratum %+v", s)
+	}
+	// 5 fathers are in 4-7, and 40 offspring in 32-63
+	if s := StratumOf(big); s != (SizeStratum{2, 5}) {
+		t.Errorf("big stratum %+v", s)
+	}
+	if s := StratumOf(TDTResult{}); s != (SizeStratum{-1, -1}) {
+		t.Errorf("empty stratum %+v", s)
+	}
+
+	bg := [][]TDTResult{
+		{
+			{P: 0.001, Totals: Family{20, 20}, Nfamilies: 4},
+			{P: 0.5, Totals: Family{25, 30}, Nfamilies: 7},
+			{P: 0.005, Totals: Family{1, 1}, Nfamilies: 1},
+		},
+		{
+			{P: 0.2, Totals: Family{16, 16}, Nfamilies: 6},
+		},
+	}
+	acc := NewMonteAccumulator([]TDTResult{big, small}, nil)
+	acc.MinMatched = 2
+	for _, rep := range bg {
+		acc.Add(rep)
+	}
+	recs := acc.Records()
+	// big's stratum holds P values 0.001, 0.5 and 0.2, one of them at most 0.01
+	if recs[0].MatchedNullAsSignificant != 1 || recs[0].MatchedNullCount != 3 || recs[0].MatchedSparse || recs[0].MatchedEmpiricalP != 0.5 {
+		t.Errorf("big record %+v", recs[0])
+	}
+	if recs[1].MatchedNullCount != 0 || !recs[1].MatchedSparse || recs[1].MatchedEmpiricalP != 1 {
+		t.Errorf("small record %+v", recs[1])
+	}
+}
@@ -1,6 +1,7 @@
 package tdt
 
 import (
+	"bufio"
 	"cmp"
 	"errors"
 	"flag"
@@ -12,10 +13,12 @@ import (
 	"io"
 	"iter"
 	"log"
+	"runtime"
 	"slices"
 )
 
-// An entry from WARP's output, an extended .ped file
+// An entry from WARP's output, an extended .ped file. Attrs holds any extra
+// columns, keyed by header name, when the file was parsed with a header.
 type Entry struct {
 	FamilyID     string
 	IndividualID string
@@ -27,6 +30,7 @@ type Entry struct {
 	Posterior    float64
 	PhenoRisk    float64
 	GenoRisk     string
+	Attrs        map[string]string `json:",omitempty"`
 }
 
 var ParseError = errors.New("entry parsing error")
@@ -54,31 +58,42 @@ func ParseLineToEntry(line []string) (Entry, error) {
 	return ent, e
 }
 
-// Parse a WARP output .ped reader to a sequence of entries
+// Parse a WARP output .ped reader to a sequence of entries. Without a
+// header, the columns must be in WARP's fixed order; with one, columns are
+// found by name, as in ParseWarpHeader.
 func ParsePed(r io.Reader, header bool) iter.Seq2[Entry, error] {
 	return func(y func(Entry, error) bool) {
 		h := csvh.Handle0("ParsePed: %w")
+		cr := csvh.CsvIn(r)
+		lineNum := 0
 		hl := func(e error, l []string) error {
-			return fmt.Errorf("ParsePed: line %v; %w", l, e)
+			return fmt.Errorf("ParsePed: line %v %v; %w", lineNum, l, e)
 		}
-		cr := csvh.CsvIn(r)
+		parse := ParseLineToEntry
 
 		if header {
-			_, e := cr.Read()
+			l, e := cr.Read()
+			lineNum++
 			if e != nil {
-				if !y(Entry{}, h(e)) {
-					return
-				}
+				y(Entry{}, h(e))
+				return
 			}
+			wh, e := ParseWarpHeader(slices.Clone(l))
+			if e != nil {
+				y(Entry{}, h(e))
+				return
+			}
+			parse = wh.Parse
 		}
 
 		for l, e := cr.Read(); e != io.EOF; l, e = cr.Read() {
+			lineNum++
 			if e != nil {
 				if !y(Entry{}, hl(e, l)) {
 					return
 				}
 			}
-			ent, e := ParseLineToEntry(l)
+			ent, e := parse(l)
 			if e != nil {
 				if !y(ent, hl(e, l)) {
 					return
@@ -91,17 +106,21 @@ func ParsePed(r io.Reader, header bool) iter.Seq2[Entry, error] {
 	}
 }
 
-// Parse WARP output .ped or .ped.gz file
+// Parse WARP output .ped or .ped.gz file. WARP cache files written by
+// warpcache are recognized by their contents and read directly, ignoring header.
 func ParsePedPath(path string, header bool) iter.Seq2[Entry, error] {
 	return func(y func(Entry, error) bool) {
 		r, e := csvh.OpenMaybeGz(path)
 		if e != nil {
-			if !y(Entry{}, e) {
-				return
-			}
+			y(Entry{}, e)
+			return
 		}
 		defer r.Close()
-		it := ParsePed(r, header)
+		br := bufio.NewReader(r)
+		it := ParsePed(br, header)
+		if IsWarpCache(br) {
+			it = WarpCacheEntries(br)
+		}
 		for ent, e := range it {
 			if !y(ent, e) {
 				return
@@ -123,10 +142,15 @@ func ParsePedPaths(header bool, paths ...string) iter.Seq[iter.Seq2[Entry, error
 
 // Get the single most significant individual
 func GetBiggestOutlier(it iter.Seq[Entry]) Entry {
+	return GetBiggestOutlierBy(it, PosteriorScore)
+}
+
+// Get the single individual with the largest score
+func GetBiggestOutlierBy(it iter.Seq[Entry], score ScoreFunc) Entry {
 	var best Entry
 	started := false
 	for ent := range it {
-		if !started || best.Posterior < ent.Posterior {
+		if !started || score(best) < score(ent) {
 			best = ent
 			started = true
 		}
@@ -136,10 +160,15 @@ func GetBiggestOutlier(it iter.Seq[Entry]) Entry {
 
 // Get the nop n most significant individuals
 func GetBiggestOutliers(it iter.Seq[Entry], n int) []Entry {
+	return GetBiggestOutliersBy(it, n, PosteriorScore)
+}
+
+// Get the n individuals with the largest scores
+func GetBiggestOutliersBy(it iter.Seq[Entry], n int, score ScoreFunc) []Entry {
 	all := slices.SortedFunc(it, iterh.Negative(func(a, b Entry) int {
-		if a.Posterior < b.Posterior {
+		if score(a) < score(b) {
 			return -1
-		} else if a.Posterior > b.Posterior {
+		} else if score(a) > score(b) {
 			return 1
 		}
 		return 0
@@ -151,25 +180,15 @@ func GetBiggestOutliers(it iter.Seq[Entry], n int) []Entry {
 }
 
 func GetBiggestOutlierPath(path string, header bool) (Entry, error) {
-	r, e := csvh.OpenMaybeGz(path)
-	if e != nil {
-		return Entry{}, e
-	}
-	defer r.Close()
-
-	it := iterh.BreakOnError(ParsePed(r, header), &e)
+	var e error
+	it := iterh.BreakOnError(ParsePedPath(path, header), &e)
 	best := GetBiggestOutlier(it)
 	return best, e
 }
 
 func GetBiggestOutliersPath(path string, header bool, n int) ([]Entry, error) {
-	r, e := csvh.OpenMaybeGz(path)
-	if e != nil {
-		return nil, e
-	}
-	defer r.Close()
-
-	it := iterh.BreakOnError(ParsePed(r, header), &e)
+	var e error
+	it := iterh.BreakOnError(ParsePedPath(path, header), &e)
 	best := GetBiggestOutliers(it, n)
 	return best, e
 }
@@ -234,22 +253,37 @@ func GetBiggestOutlierAvg(bgOutliers iter.Seq[Entry]) float64 {
 
 // Extract and normalize all posteriors
 func GetZscores(ents []Entry) ([]float64, error) {
+	return GetZscoresBy(ents, PosteriorScore)
+}
+
+// Extract and normalize all scores
+func GetZscoresBy(ents []Entry, score ScoreFunc) ([]float64, error) {
+	return GetNormalizedBy(ents, score, NormZ)
+}
+
+// Extract all scores and normalize them with norm
+func GetNormalizedBy(ents []Entry, score ScoreFunc, norm Normalization) ([]float64, error) {
 	fs := make([]float64, 0, len(ents))
 	for _, ent := range ents {
-		fs = append(fs, ent.Posterior)
+		fs = append(fs, score(ent))
 	}
-	return Zscores(fs)
+	return Normalize(fs, norm)
 }
 
 // Extract and normalize all posteriors for each of the entry sets, then get the highest z score for each one
 func GetAllBestZscores(its iter.Seq[iter.Seq2[Entry, error]]) ([]float64, error) {
+	return GetAllBestNormalized(its, PosteriorScore, NormZ)
+}
+
+// Like GetAllBestZscores, but for any score and normalization
+func GetAllBestNormalized(its iter.Seq[iter.Seq2[Entry, error]], score ScoreFunc, norm Normalization) ([]float64, error) {
 	var bests []float64
 	for it := range its {
 		ents, e := iterh.CollectWithError(it)
 		if e != nil {
 			return nil, e
 		}
-		zs, e := GetZscores(ents)
+		zs, e := GetNormalizedBy(ents, score, norm)
 		if e != nil {
 			return nil, e
 		}
@@ -261,13 +295,18 @@ func GetAllBestZscores(its iter.Seq[iter.Seq2[Entry, error]]) ([]float64, error)
 
 // Like GetAllBestZscores, but get the top n
 func GetAllBestZscoresTopN(its iter.Seq[iter.Seq2[Entry, error]], n int) ([][]float64, error) {
+	return GetAllBestNormalizedTopN(its, n, PosteriorScore, NormZ)
+}
+
+// Like GetAllBestZscoresTopN, but for any score and normalization
+func GetAllBestNormalizedTopN(its iter.Seq[iter.Seq2[Entry, error]], n int, score ScoreFunc, norm Normalization) ([][]float64, error) {
 	var bests [][]float64
 	for it := range its {
 		ents, e := iterh.CollectWithError(it)
 		if e != nil {
 			return nil, e
 		}
-		zs, e := GetZscores(ents)
+		zs, e := GetNormalizedBy(ents, score, norm)
 		if e != nil {
 			return nil, e
 		}
@@ -279,9 +318,14 @@ func GetAllBestZscoresTopN(its iter.Seq[iter.Seq2[Entry, error]], n int) ([][]fl
 
 // Get the posterior of a specific id and return if it was found
 func GetIDPosterior(id string, ents iter.Seq[Entry]) (float64, bool) {
+	return GetIDScore(id, ents, PosteriorScore)
+}
+
+// Get the score of a specific id and return if it was found
+func GetIDScore(id string, ents iter.Seq[Entry], score ScoreFunc) (float64, bool) {
 	for ent := range ents {
 		if ent.IndividualID == id {
-			return ent.Posterior, true
+			return score(ent), true
 		}
 	}
 	return 0, false
@@ -289,8 +333,13 @@ func GetIDPosterior(id string, ents iter.Seq[Entry]) (float64, bool) {
 
 // Get the posterior of a specific ID for each set; couns number of times it was missed.
 func GetAllIDPosteriors(id string, its iter.Seq[iter.Seq2[Entry, error]]) (vals []float64, nmissing int, err error) {
+	return GetAllIDScores(id, its, PosteriorScore)
+}
+
+// Get the score of a specific ID for each set; counts number of times it was missed.
+func GetAllIDScores(id string, its iter.Seq[iter.Seq2[Entry, error]], score ScoreFunc) (vals []float64, nmissing int, err error) {
 	for it := range its {
-		val, ok := GetIDPosterior(id, iterh.BreakOnError(it, &err))
+		val, ok := GetIDScore(id, iterh.BreakOnError(it, &err), score)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -303,46 +352,14 @@ func GetAllIDPosteriors(id string, its iter.Seq[iter.Seq2[Entry, error]]) (vals
 	return vals, nmissing, nil
 }
 
-// Calculate the rank of the chosen ID compared to itself in the background and compared to all other IDs in the real pedigree
-func RankStats(id string, realPed iter.Seq[Entry], bgPeds iter.Seq[iter.Seq2[Entry, error]]) (chosenRank, chosenInternalRank float64, bgRanks []float64, err error) {
-	idx, realIDVal := iterh.IndexFunc(realPed, func(ent Entry) bool {
-		return ent.IndividualID == id
-	})
-	if idx == -1 {
-		return 0, 0, nil, fmt.Errorf("could not find id %v in realPed", id)
-	}
-
-	chosenInternalRank, _, _ = iterh.Rank(realIDVal.Posterior, iterh.Transform(realPed, func(ent Entry) float64 {
-		return ent.Posterior
-	}))
-
-	bgIDVals, nmissing, err := GetAllIDPosteriors(id, bgPeds)
-	if err != nil {
-		return 0, 0, nil, err
-	}
-	if nmissing > 0 {
-		return 0, 0, nil, fmt.Errorf("RankStats: nmissing %v", nmissing)
-	}
-	chosenRank, _, _ = iterh.Rank(realIDVal.Posterior, iterh.SliceIter(bgIDVals))
-
-	for i, bgPed := range iterh.Enumerate(bgPeds) {
-		bgRank, _, _ := iterh.Rank(bgIDVals[i], iterh.Transform(iterh.BreakOnError(bgPed, &err), func(ent Entry) float64 {
-			return ent.Posterior
-		}))
-		if err != nil {
-			return 0, 0, nil, err
-		}
-		bgRanks = append(bgRanks, bgRank)
-	}
-
-	return chosenRank, chosenInternalRank, bgRanks, nil
-}
-
 // Get posteriors from entries
 func Posteriors(it iter.Seq[Entry]) iter.Seq[float64] {
-	return iterh.Transform(it, func(ent Entry) float64 {
-		return ent.Posterior
-	})
+	return Scores(it, PosteriorScore)
+}
+
+// Get scores from entries
+func Scores(it iter.Seq[Entry], score ScoreFunc) iter.Seq[float64] {
+	return iterh.Transform(it, score)
 }
 
 // Get the top n of anything
@@ -365,7 +382,14 @@ type Flags struct {
 	BgPathsPath string
 	BgHeader    bool
 	Chosen      string
+	Candidates  string
 	TopN        int
+	Format      string
+	OutPath     string
+	Workers     int
+	Score       string
+	Tail        string
+	Norm        string
 }
 
 // bgZScoresMeans, e := GetBgZScoresMeans(bgZScores)
@@ -383,15 +407,176 @@ func GetBgZScoresMeans(bgZScores [][]float64) ([]float64, error) {
 	return out, nil
 }
 
+// Compare the real WARP output to the background outputs in bgPaths. With
+// opt.TopN < 1, each pedigree is scored by its single largest score;
+// otherwise by the mean of its TopN largest. If opt.Chosen is not empty, also
+// report rank statistics for that individual, and likewise for each of
+// opt.Candidates, with combined tests for the set. Unless tail is TailNone, also
+// fit it to the background outlier values and extrapolate the real outlier's
+// p-value. Each background file is read once, with nworkers files read at a
+// time. Z scores are normalized with opt.Norm. Entries whose score is not
+// finite are left out, real and background alike.
+func OutlierStats(realEntries []Entry, bgPaths []string, opt BackgroundOptions, tail TailModel, nworkers int) (OutlierReport, error) {
+	h := csvh.Handle1[OutlierReport]("OutlierStats: %w")
+	var r OutlierReport
+	var e error
+	if opt.Score == nil {
+		opt.Score = PosteriorScore
+	}
+	if opt.Norm == "" {
+		opt.Norm = NormZ
+	}
+	r.Normalization = string(opt.Norm)
+	realEntries, r.RealSkipped = FiniteEntries(realEntries, opt.Score)
+	if len(realEntries) < 1 {
+		return h(fmt.Errorf("no real entries with a finite score"))
+	}
+
+	bgs, e := SummarizeBackgrounds(bgPaths, opt, nworkers)
+	if e != nil {
+		return h(e)
+	}
+	r.BackgroundSkipped = SkippedScores(bgs)
+	bgOutlierVals := make([]float64, 0, len(bgs))
+	bgZs := make([]float64, 0, len(bgs))
+
+	realZs, e := GetNormalizedBy(realEntries, opt.Score, opt.Norm)
+	if e != nil {
+		return h(e)
+	}
+	if opt.TopN < 1 {
+		r.TopN = 1
+		r.RealOutlier = opt.Score(GetBiggestOutlierBy(iterh.SliceIter(realEntries), opt.Score))
+		r.RealZ = iterh.Max(iterh.SliceIter(realZs))
+		for _, bg := range bgs {
+			bgOutlierVals = append(bgOutlierVals, bg.Max().Score)
+			bgZs = append(bgZs, bg.MaxZ())
+		}
+	} else {
+		r.TopN = opt.TopN
+		realOutliers := GetBiggestOutliersBy(iterh.SliceIter(realEntries), opt.TopN, opt.Score)
+		if r.RealOutlier, e = stats.Mean(slices.Collect(Scores(slices.Values(realOutliers), opt.Score))); e != nil {
+			return h(e)
+		}
+		if r.RealZ, e = stats.Mean(TopN(iterh.SliceIter(realZs), opt.TopN)); e != nil {
+			return h(e)
+		}
+		for _, bg := range bgs {
+			bgOutlierVals = append(bgOutlierVals, bg.TopMean())
+			bgZs = append(bgZs, bg.TopZMean())
+		}
+	}
+	r.OutlierRank, _, _ = iterh.Rank(r.RealOutlier, slices.Values(bgOutlierVals))
+	if r.BackgroundOutlier, e = SummarizeDist(bgOutlierVals); e != nil {
+		return h(e)
+	}
+	if tail != TailNone {
+		fit, e := FitTail(tail, bgOutlierVals, 0)
+		if e != nil {
+			return h(e)
+		}
+		p, _ := fit.P(r.RealOutlier)
+		r.Tail, r.TailP = &fit, &p
+	}
+	r.ZRank, r.ZHigher, r.ZTotal = iterh.Rank(r.RealZ, iterh.SliceIter(bgZs))
+	if r.BackgroundZ, e = SummarizeDist(bgZs); e != nil {
+		return h(e)
+	}
+
+	if opt.Chosen != "" {
+		c, e := ChosenStats(opt.Chosen, realEntries, bgs, opt.Score)
+		if e != nil {
+			return h(e)
+		}
+		r.Chosen = &c
+	}
+	if len(opt.Candidates) > 0 {
+		cs, e := CandidateStats(opt.Candidates, realEntries, bgs, opt.Score)
+		if e != nil {
+			return h(e)
+		}
+		r.Candidates = &cs
+	}
+
+	return r, nil
+}
+
+// Rank statistics for the chosen individual: its rank against its own
+// values in the backgrounds and against the other individuals of the real
+// pedigree, from background summaries made with the same chosen ID and score. Backgrounds without the
+// chosen individual are counted in NMissing and left out; it is an error only
+// if no background has it. A t test that cannot run is recorded in TTestError
+// rather than returned as an error.
+func ChosenStats(chosen string, realEntries []Entry, bgs []BackgroundSummary, score ScoreFunc) (ChosenReport, error) {
+	c := ChosenReport{ID: chosen}
+	idx, realIDVal := iterh.IndexFunc(slices.Values(realEntries), func(ent Entry) bool {
+		return ent.IndividualID == chosen
+	})
+	if idx == -1 {
+		return c, fmt.Errorf("could not find id %v in realPed", chosen)
+	}
+	c.InternalRank, _, _ = iterh.Rank(score(realIDVal), Scores(slices.Values(realEntries), score))
+
+	bgIDVals := make([]float64, 0, len(bgs))
+	bgRanks := make([]float64, 0, len(bgs))
+	for _, bg := range bgs {
+		if !bg.ChosenFound {
+			c.NMissing++
+			continue
+		}
+		bgIDVals = append(bgIDVals, bg.ChosenScore)
+		bgRanks = append(bgRanks, bg.ChosenRank)
+	}
+	c.NBackground = len(bgIDVals)
+	if c.NBackground < 1 {
+		return c, fmt.Errorf("ChosenStats: id %v is in none of %v background pedigrees", chosen, len(bgs))
+	}
+	c.Rank, _, _ = iterh.Rank(score(realIDVal), slices.Values(bgIDVals))
+
+	var e error
+	if c.BackgroundRanks, e = SummarizeDist(bgRanks); e != nil {
+		return c, e
+	}
+	res, e := stat.OneSampleTTest(stat.Sample{Xs: bgRanks}, 0.5, 0)
+	if e != nil {
+		c.TTestError = e.Error()
+		return c, nil
+	}
+	t := NewTTestReport(0.5, res)
+	c.TTest = &t
+	return c, nil
+}
+
+// Write an outlier report in format "text", "json" or "tsv"
+func WriteOutlierReport(w io.Writer, format string, r OutlierReport) error {
+	switch format {
+	case "text":
+		return WriteOutlierText(w, r)
+	case "json":
+		return WriteOutlierJSON(w, r)
+	case "tsv":
+		return WriteOutlierTSV(w, r)
+	default:
+		return fmt.Errorf("WriteOutlierReport: unknown format %q", format)
+	}
+}
+
 // Run all outlier code on the command line.
 func RunOutlier() {
 	var f Flags
 	flag.StringVar(&f.RealPath, "r", "", "Path to output of warp for real data")
-	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data")
+	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data, or a pedshufsex replicate manifest")
 	flag.StringVar(&f.Chosen, "c", "", "Chosen individual ID to run rank order statistics on")
+	flag.StringVar(&f.Candidates, "cf", "", "Path to a file of candidate individual IDs, one per line, to run rank order statistics on individually and as a set")
 	flag.BoolVar(&f.RealHeader, "rh", false, "Real data has a header line")
 	flag.BoolVar(&f.BgHeader, "bh", false, "Background data has a header line")
 	flag.IntVar(&f.TopN, "t", -1, "Top number of individuals to average to get score (default 1)")
+	flag.StringVar(&f.Format, "f", "text", "Output format: text, json or tsv")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write output (default stdout)")
+	flag.IntVar(&f.Workers, "w", runtime.NumCPU(), "Number of background files to read in parallel")
+	flag.StringVar(&f.Score, "score", "posterior", "WARP column or derived score to rank individuals by: "+ScoreFuncNames())
+	flag.StringVar(&f.Tail, "tail", "none", "Fit none, gumbel or gev to the background outlier values and report extrapolated p-values")
+	flag.StringVar(&f.Norm, "norm", "z", "Normalize scores within each pedigree by z (mean and SD), robust (median and MAD), rank (rank inverse normal) or logit (z scores of logits)")
 
 	flag.Parse()
 	if f.RealPath == "" {
@@ -406,110 +591,36 @@ func RunOutlier() {
 		log.Fatal(e)
 	}
 
-	bgPaths := iterh.Collect(iterh.BreakOnError(iterh.PathIter(f.BgPathsPath, iterh.LineIter), &e))
+	bgPaths, e := BackgroundPaths(f.BgPathsPath)
 	if e != nil {
 		log.Fatal(e)
 	}
 
-	if f.TopN == -1 {
-		realOutlier := GetBiggestOutlier(iterh.SliceIter(realEntries))
-
-		bgOutliersSeq := iterh.BreakOnError(GetBiggestOutlierPaths(iterh.SliceIter[[]string](bgPaths), f.BgHeader), &e)
-		bgOutliers := iterh.Collect(bgOutliersSeq)
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		frac := BiggestOutlierPerc(realOutlier, iterh.SliceIter(bgOutliers))
-		fmt.Println("biggest outlier percentage:", frac)
-
-		bgAvg := GetBiggestOutlierAvg(iterh.SliceIter(bgOutliers))
-		fmt.Println("backgrount biggest average:", bgAvg)
-	} else {
-		realOutliers := GetBiggestOutliers(iterh.SliceIter(realEntries), f.TopN)
-		realOutliersMean, e := stats.Mean(slices.Collect(Posteriors(slices.Values(realOutliers))))
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		bgOutliersSeq := iterh.BreakOnError(GetBiggestOutliersPaths(iterh.SliceIter[[]string](bgPaths), f.BgHeader, f.TopN), &e)
-		bgOutliers := iterh.Collect(bgOutliersSeq)
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		bgOutliersMeans, e := iterh.CollectWithError(PosteriorMeans(slices.Values(bgOutliers)))
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		frac, _, _ := iterh.Rank(realOutliersMean, slices.Values(bgOutliersMeans))
-		if e != nil {
-			log.Fatal(e)
-		}
-		fmt.Println("biggest outlier percentage:", frac)
-
-		bgAvg, e := stats.Mean(bgOutliersMeans)
-		if e != nil {
-			log.Fatal(e)
-		}
-		fmt.Println("backgrount biggest average:", bgAvg)
+	score, e := ParseScoreFunc(f.Score)
+	if e != nil {
+		log.Fatal(e)
 	}
-
-	if f.Chosen != "" {
-		chosenRank, chosenInternalRank, bgRanks, e := RankStats(f.Chosen, iterh.SliceIter(realEntries), ParsePedPaths(f.BgHeader, bgPaths...))
-		if e != nil {
-			log.Fatal(e)
-		}
-		meanBgRank, e := stats.Mean(bgRanks)
-		if e != nil {
+	norm := Normalization(f.Norm)
+	Must(norm.Validate())
+	opt := BackgroundOptions{Header: f.BgHeader, TopN: f.TopN, Chosen: f.Chosen, Score: score, Norm: norm}
+	if f.Candidates != "" {
+		if opt.Candidates, e = ReadCandidatesPath(f.Candidates); e != nil {
 			log.Fatal(e)
 		}
-		fmt.Printf("chosenRank %v; chosenInternalRank %v; meanBgRank %v\n", chosenRank, chosenInternalRank, meanBgRank)
-		sample := stat.Sample{Xs: bgRanks}
-		res, e := stat.OneSampleTTest(sample, 0.5, 0)
-		if e != nil {
-			log.Fatal(e)
-		}
-		fmt.Printf("t test results: %#v\n", res)
 	}
-
-	if f.TopN == -1 {
-		realZs, e := GetZscores(realEntries)
-		if e != nil {
-			log.Fatal(e)
-		}
-		realHighestZ := iterh.Max(iterh.SliceIter(realZs))
-		bgZScores, e := GetAllBestZscores(ParsePedPaths(f.BgHeader, bgPaths...))
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		zrankperc, zhigher, ztotal := iterh.Rank(realHighestZ, iterh.SliceIter(bgZScores))
-		fmt.Printf("realHighestZ %v; zrankperc %v; zhigher %v; ztotal %v\n", realHighestZ, zrankperc, zhigher, ztotal)
-	} else {
-		realZs, e := GetZscores(realEntries)
-		if e != nil {
-			log.Fatal(e)
-		}
-		realHighestZs := TopN(iterh.SliceIter(realZs), f.TopN)
-		realHighestZsMean, e := stats.Mean(realHighestZs)
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		bgZScores, e := GetAllBestZscoresTopN(ParsePedPaths(f.BgHeader, bgPaths...), f.TopN)
-		if e != nil {
-			log.Fatal(e)
-		}
-		bgZScoresMeans, e := GetBgZScoresMeans(bgZScores)
-		if e != nil {
-			log.Fatal(e)
-		}
-
-		zrankperc, zhigher, ztotal := iterh.Rank(realHighestZsMean, iterh.SliceIter(bgZScoresMeans))
-		fmt.Printf("realHighestZ %v; zrankperc %v; zhigher %v; ztotal %v\n", realHighestZsMean, zrankperc, zhigher, ztotal)
+	tail := TailModel(f.Tail)
+	if tail == TailGPD {
+		log.Fatal(fmt.Errorf("-tail gpd is for pooled values; use gumbel or gev for background outliers"))
 	}
+	Must(tail.Validate())
+	report, e := OutlierStats(realEntries, bgPaths, opt, tail, f.Workers)
+	if e != nil {
+		log.Fatal(e)
+	}
+	report.Score = f.Score
+	Must(WriteOutput(f.OutPath, func(w io.Writer) error {
+		return WriteOutlierReport(w, f.Format, report)
+	}))
 }
 
 // family - Family ID
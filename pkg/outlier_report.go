@@ -0,0 +1,294 @@
+package tdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jgbaldwinbrown/csvh"
+	stat "github.com/jgbaldwinbrown/perf/pkg/stats"
+	"github.com/montanaflynn/stats"
+)
+
+// Summary statistics of a distribution of values
+type DistSummary struct {
+	N      int
+	Mean   float64
+	SD     float64
+	Min    float64
+	Median float64
+	Max    float64
+}
+
+// Summarize a distribution. An empty distribution gives a summary with N == 0.
+func SummarizeDist(fs []float64) (DistSummary, error) {
+	s := DistSummary{N: len(fs)}
+	if len(fs) < 1 {
+		return s, nil
+	}
+	h := csvh.Handle1[DistSummary]("SummarizeDist: %w")
+	var e error
+	if s.Mean, e = stats.Mean(fs); e != nil {
+		return h(e)
+	}
+	if s.SD, e = stats.StandardDeviation(fs); e != nil {
+		return h(e)
+	}
+	if s.Min, e = stats.Min(fs); e != nil {
+		return h(e)
+	}
+	if s.Median, e = stats.Median(fs); e != nil {
+		return h(e)
+	}
+	if s.Max, e = stats.Max(fs); e != nil {
+		return h(e)
+	}
+	return s, nil
+}
+
+// The result of a one-sample t test, under stable field names
+type TTestReport struct {
+	N             int
+	Mu0           float64
+	T             float64
+	DoF           float64
+	AltHypothesis int
+	P             float64
+}
+
+func NewTTestReport(mu0 float64, r *stat.TTestResult) TTestReport {
+	return TTestReport{
+		N:             r.N1,
+		Mu0:           mu0,
+		T:             r.T,
+		DoF:           r.DoF,
+		AltHypothesis: int(r.AltHypothesis),
+		P:             r.P,
+	}
+}
+
+// Rank statistics of the chosen individual. Rank is the fraction of
+// background values of the same individual above its real value;
+// InternalRank is the fraction of real individuals above it; BackgroundRanks
+// are its internal ranks within each background pedigree, tested against 0.5.
+// NBackground and NMissing count the background pedigrees with and without the
+// chosen individual; pedigrees without it are left out, as with candidates.
+// If the t test cannot run, for example with a single background or with the
+// same rank in every background, TTest is nil and TTestError says why.
+type ChosenReport struct {
+	ID              string
+	Rank            float64
+	InternalRank    float64
+	NBackground     int
+	NMissing        int
+	BackgroundRanks DistSummary
+	TTest           *TTestReport `json:",omitempty"`
+	TTestError      string       `json:",omitempty"`
+}
+
+// Everything the outlier command computes. Score names the value individuals
+// are ranked by. Outlier values are the largest score in each pedigree, or
+// the mean of its TopN largest. OutlierRank is the fraction of background
+// outlier values larger than the real one, and ZRank the same for the largest
+// z scores, which are normalized within each pedigree as named by
+// Normalization. If a tail model was fitted to the background outlier values,
+// Tail describes the fit and TailP is the real outlier's extrapolated p-value.
+// Chosen and Candidates hold rank statistics of the individuals given with -c
+// and -cf. RealSkipped and BackgroundSkipped count the entries left out
+// because their score was not finite (see FiniteScore).
+type OutlierReport struct {
+	Score             string
+	TopN              int
+	RealSkipped       int
+	BackgroundSkipped int
+	RealOutlier       float64
+	BackgroundOutlier DistSummary
+	OutlierRank       float64
+	Normalization     string
+	RealZ             float64
+	BackgroundZ       DistSummary
+	ZRank             float64
+	ZHigher           int
+	ZTotal            int
+	Chosen            *ChosenReport       `json:",omitempty"`
+	Candidates        *CandidateSetReport `json:",omitempty"`
+	Tail              *TailFit            `json:",omitempty"`
+	TailP             *float64            `json:",omitempty"`
+}
+
+// Write the report as one indented JSON document
+func WriteOutlierJSON(w io.Writer, r OutlierReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r)
+}
+
+func appendDistFields(out [][2]string, prefix string, d DistSummary) [][2]string {
+	return append(out,
+		[2]string{prefix + ".N", fmt.Sprint(d.N)},
+		[2]string{prefix + ".Mean", fmt.Sprint(d.Mean)},
+		[2]string{prefix + ".SD", fmt.Sprint(d.SD)},
+		[2]string{prefix + ".Min", fmt.Sprint(d.Min)},
+		[2]string{prefix + ".Median", fmt.Sprint(d.Median)},
+		[2]string{prefix + ".Max", fmt.Sprint(d.Max)},
+	)
+}
+
+func appendTailFields(out [][2]string, prefix string, t TailFit) [][2]string {
+	return append(out,
+		[2]string{prefix + ".Model", string(t.Model)},
+		[2]string{prefix + ".Mu", fmt.Sprint(t.Mu)},
+		[2]string{prefix + ".Sigma", fmt.Sprint(t.Sigma)},
+		[2]string{prefix + ".Xi", fmt.Sprint(t.Xi)},
+		[2]string{prefix + ".ExceedFrac", fmt.Sprint(t.ExceedFrac)},
+		[2]string{prefix + ".Converged", fmt.Sprint(t.Converged)},
+		[2]string{prefix + ".Fit.N", fmt.Sprint(t.Fit.N)},
+		[2]string{prefix + ".Fit.LogLik", fmt.Sprint(t.Fit.LogLik)},
+		[2]string{prefix + ".Fit.KS", fmt.Sprint(t.Fit.KS)},
+		[2]string{prefix + ".Fit.KSP", fmt.Sprint(t.Fit.KSP)},
+		[2]string{prefix + ".Fit.AD", fmt.Sprint(t.Fit.AD)},
+	)
+}
+
+func appendCandidateFields(out [][2]string, prefix string, cs CandidateSetReport) [][2]string {
+	out = append(out,
+		[2]string{prefix + ".NCandidates", fmt.Sprint(cs.NCandidates)},
+		[2]string{prefix + ".NUsed", fmt.Sprint(cs.NUsed)},
+		[2]string{prefix + ".RealMeanRank", fmt.Sprint(cs.RealMeanRank)},
+	)
+	out = appendDistFields(out, prefix+".BackgroundMeanRanks", cs.BackgroundMeanRanks)
+	out = append(out,
+		[2]string{prefix + ".PermutationP", fmt.Sprint(cs.PermutationP)},
+		[2]string{prefix + ".Wilcoxon.N", fmt.Sprint(cs.Wilcoxon.N)},
+		[2]string{prefix + ".Wilcoxon.W", fmt.Sprint(cs.Wilcoxon.W)},
+		[2]string{prefix + ".Wilcoxon.Z", fmt.Sprint(cs.Wilcoxon.Z)},
+		[2]string{prefix + ".Wilcoxon.P", fmt.Sprint(cs.Wilcoxon.P)},
+	)
+	for _, c := range cs.Candidates {
+		p := prefix + "." + c.ID
+		out = append(out,
+			[2]string{p + ".FoundInReal", fmt.Sprint(c.FoundInReal)},
+			[2]string{p + ".Score", fmt.Sprint(c.Score)},
+			[2]string{p + ".InternalRank", fmt.Sprint(c.InternalRank)},
+			[2]string{p + ".Rank", fmt.Sprint(c.Rank)},
+			[2]string{p + ".NBackground", fmt.Sprint(c.NBackground)},
+			[2]string{p + ".NMissing", fmt.Sprint(c.NMissing)},
+		)
+		out = appendDistFields(out, p+".BackgroundRanks", c.BackgroundRanks)
+		out = append(out, [2]string{p + ".RankShift", fmt.Sprint(c.RankShift)})
+	}
+	return out
+}
+
+// Write the report as a two-column Field/Value TSV, using the JSON field
+// names joined with dots. Each candidate is named by its ID in place of the
+// Candidates list, as in Candidates.<ID>.Rank.
+func WriteOutlierTSV(w io.Writer, r OutlierReport) error {
+	fields := [][2]string{
+		{"Score", r.Score},
+		{"TopN", fmt.Sprint(r.TopN)},
+		{"RealSkipped", fmt.Sprint(r.RealSkipped)},
+		{"BackgroundSkipped", fmt.Sprint(r.BackgroundSkipped)},
+		{"RealOutlier", fmt.Sprint(r.RealOutlier)},
+	}
+	fields = appendDistFields(fields, "BackgroundOutlier", r.BackgroundOutlier)
+	fields = append(fields,
+		[2]string{"OutlierRank", fmt.Sprint(r.OutlierRank)},
+		[2]string{"Normalization", r.Normalization},
+		[2]string{"RealZ", fmt.Sprint(r.RealZ)},
+	)
+	fields = appendDistFields(fields, "BackgroundZ", r.BackgroundZ)
+	fields = append(fields,
+		[2]string{"ZRank", fmt.Sprint(r.ZRank)},
+		[2]string{"ZHigher", fmt.Sprint(r.ZHigher)},
+		[2]string{"ZTotal", fmt.Sprint(r.ZTotal)},
+	)
+	if t := r.Tail; t != nil {
+		fields = appendTailFields(fields, "Tail", *t)
+	}
+	if r.TailP != nil {
+		fields = append(fields, [2]string{"TailP", fmt.Sprint(*r.TailP)})
+	}
+	if c := r.Chosen; c != nil {
+		fields = append(fields,
+			[2]string{"Chosen.ID", c.ID},
+			[2]string{"Chosen.Rank", fmt.Sprint(c.Rank)},
+			[2]string{"Chosen.InternalRank", fmt.Sprint(c.InternalRank)},
+			[2]string{"Chosen.NBackground", fmt.Sprint(c.NBackground)},
+			[2]string{"Chosen.NMissing", fmt.Sprint(c.NMissing)},
+		)
+		fields = appendDistFields(fields, "Chosen.BackgroundRanks", c.BackgroundRanks)
+		if t := c.TTest; t != nil {
+			fields = append(fields,
+				[2]string{"Chosen.TTest.N", fmt.Sprint(t.N)},
+				[2]string{"Chosen.TTest.Mu0", fmt.Sprint(t.Mu0)},
+				[2]string{"Chosen.TTest.T", fmt.Sprint(t.T)},
+				[2]string{"Chosen.TTest.DoF", fmt.Sprint(t.DoF)},
+				[2]string{"Chosen.TTest.AltHypothesis", fmt.Sprint(t.AltHypothesis)},
+				[2]string{"Chosen.TTest.P", fmt.Sprint(t.P)},
+			)
+		}
+		if c.TTestError != "" {
+			fields = append(fields, [2]string{"Chosen.TTestError", c.TTestError})
+		}
+	}
+	if cs := r.Candidates; cs != nil {
+		fields = appendCandidateFields(fields, "Candidates", *cs)
+	}
+
+	cw := csvh.CsvOut(w)
+	if e := cw.Write([]string{"Field", "Value"}); e != nil {
+		return e
+	}
+	for _, f := range fields {
+		if e := cw.Write(f[:]); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write the report as human-readable lines
+func WriteOutlierText(w io.Writer, r OutlierReport) error {
+	if r.RealSkipped > 0 || r.BackgroundSkipped > 0 {
+		if _, e := fmt.Fprintf(w, "skipped non-finite scores: real %v; background %v\n", r.RealSkipped, r.BackgroundSkipped); e != nil {
+			return e
+		}
+	}
+	if _, e := fmt.Fprintln(w, "biggest outlier percentage:", r.OutlierRank); e != nil {
+		return e
+	}
+	if _, e := fmt.Fprintln(w, "background biggest average:", r.BackgroundOutlier.Mean); e != nil {
+		return e
+	}
+	if t := r.Tail; t != nil && r.TailP != nil {
+		if _, e := fmt.Fprintf(w, "%v tail p: %v; KS %v (p %v); AD %v; converged %v\n", t.Model, *r.TailP, t.Fit.KS, t.Fit.KSP, t.Fit.AD, t.Converged); e != nil {
+			return e
+		}
+	}
+	if c := r.Chosen; c != nil {
+		if _, e := fmt.Fprintf(w, "chosenRank %v; chosenInternalRank %v; meanBgRank %v; nbg %v; nmissing %v\n", c.Rank, c.InternalRank, c.BackgroundRanks.Mean, c.NBackground, c.NMissing); e != nil {
+			return e
+		}
+		if t := c.TTest; t != nil {
+			if _, e := fmt.Fprintf(w, "t test results: N %v; T %v; DoF %v; P %v\n", t.N, t.T, t.DoF, t.P); e != nil {
+				return e
+			}
+		} else if _, e := fmt.Fprintf(w, "t test not run: %v\n", c.TTestError); e != nil {
+			return e
+		}
+	}
+	if cs := r.Candidates; cs != nil {
+		for _, c := range cs.Candidates {
+			if _, e := fmt.Fprintf(w, "candidate %v: found %v; rank %v; internalRank %v; meanBgRank %v; nbg %v; nmissing %v\n", c.ID, c.FoundInReal, c.Rank, c.InternalRank, c.BackgroundRanks.Mean, c.NBackground, c.NMissing); e != nil {
+				return e
+			}
+		}
+		if _, e := fmt.Fprintf(w, "candidates used %v of %v; realMeanRank %v; meanBgMeanRank %v; permutation p %v; wilcoxon N %v; W %v; Z %v; P %v\n", cs.NUsed, cs.NCandidates, cs.RealMeanRank, cs.BackgroundMeanRanks.Mean, cs.PermutationP, cs.Wilcoxon.N, cs.Wilcoxon.W, cs.Wilcoxon.Z, cs.Wilcoxon.P); e != nil {
+			return e
+		}
+	}
+	_, e := fmt.Fprintf(w, "normalization %v; realHighestZ %v; zrankperc %v; zhigher %v; ztotal %v\n", r.Normalization, r.RealZ, r.ZRank, r.ZHigher, r.ZTotal)
+	return e
+}
@@ -0,0 +1,202 @@
+package tdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+// Run OutlierStats on exampleWarp against backgrounds in which individual 6
+// has posterior 0.3, 0.4, 0.5, 0.6 and 0.7
+func exampleOutlierReport(t *testing.T) OutlierReport {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("bg%v.txt", i))
+		bg := strings.ReplaceAll(exampleWarp, "0.3\t0.1\t", fmt.Sprintf("0.3\t%v\t", 0.3+float64(i)/10))
+		if e := os.WriteFile(path, []byte(bg), 0644); e != nil {
+			t.Fatal(e)
+		}
+		paths = append(paths, path)
+	}
+	real, e := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarp), false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	opt := BackgroundOptions{Chosen: "6", Candidates: []string{"6", "5"}}
+	r, e := OutlierStats(real, paths, opt, TailNone, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return r
+}
+
+func TestOutlierStats(t *testing.T) {
+	r := exampleOutlierReport(t)
+	// background maxima are 0.5, 0.5, 0.5, 0.6 and 0.7; the real maximum is 0.5
+	if r.Score != "" || r.TopN != 1 || r.RealOutlier != 0.5 || r.OutlierRank != 0.4 {
+		t.Errorf("outlier %v, rank %v", r.RealOutlier, r.OutlierRank)
+	}
+	if b := r.BackgroundOutlier; b.N != 5 || b.Min != 0.5 || b.Median != 0.5 || b.Max != 0.7 {
+		t.Errorf("background outliers %+v", b)
+	}
+	if r.Normalization != string(NormZ) || r.ZTotal != 5 {
+		t.Errorf("z normalization %v, total %v", r.Normalization, r.ZTotal)
+	}
+	// 6 has the lowest real posterior, and every background value is above it
+	c := r.Chosen
+	if c == nil || c.ID != "6" || c.Rank != 1 || c.InternalRank != 6.0/7.0 || c.BackgroundRanks.N != 5 {
+		t.Errorf("chosen %+v", c)
+	}
+	if r.Candidates == nil || r.Candidates.NCandidates != 2 || r.Candidates.NUsed != 2 {
+		t.Errorf("candidates %+v", r.Candidates)
+	}
+	if r.Tail != nil || r.TailP != nil {
+		t.Errorf("tail fitted with TailNone")
+	}
+}
+
+// Flatten decoded JSON to dotted field names as WriteOutlierTSV names them:
+// list elements are named by their ID in place of the list's name
+func flattenJSON(prefix string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			flattenJSON(prefix+"."+k, x, out)
+		}
+	case []any:
+		parent := prefix[:strings.LastIndex(prefix, ".")]
+		for _, x := range v {
+			elem := maps.Clone(x.(map[string]any))
+			id := elem["ID"]
+			delete(elem, "ID")
+			flattenJSON(parent+"."+fmt.Sprint(id), elem, out)
+		}
+	default:
+		out[strings.TrimPrefix(prefix, ".")] = fmt.Sprint(v)
+	}
+}
+
+func TestWriteOutlierReport(t *testing.T) {
+	r := exampleOutlierReport(t)
+	p := 0.25
+	r.Tail = &TailFit{Model: TailGPD, Mu: 0.5, Sigma: 0.1, Xi: 0.2, ExceedFrac: 0.4, Converged: true, Fit: GoodnessOfFit{N: 2, LogLik: -1, KS: 0.3, KSP: 0.9, AD: 0.5}}
+	r.TailP = &p
+
+	var jbuf bytes.Buffer
+	if e := WriteOutlierJSON(&jbuf, r); e != nil {
+		t.Fatal(e)
+	}
+	var back OutlierReport
+	if e := json.Unmarshal(jbuf.Bytes(), &back); e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(back, r) {
+		t.Errorf("JSON round trip %+v != %+v", back, r)
+	}
+
+	var decoded map[string]any
+	if e := json.Unmarshal(jbuf.Bytes(), &decoded); e != nil {
+		t.Fatal(e)
+	}
+	jfields := map[string]string{}
+	flattenJSON("", decoded, jfields)
+
+	var tbuf bytes.Buffer
+	if e := WriteOutlierTSV(&tbuf, r); e != nil {
+		t.Fatal(e)
+	}
+	lines := strings.Split(strings.TrimSuffix(tbuf.String(), "\n"), "\n")
+	if lines[0] != "Field\tValue" {
+		t.Errorf("TSV header %q", lines[0])
+	}
+	tfields := map[string]string{}
+	for _, line := range lines[1:] {
+		f := strings.Split(line, "\t")
+		if len(f) != 2 {
+			t.Fatalf("TSV line %q", line)
+		}
+		tfields[f[0]] = f[1]
+	}
+	if !maps.Equal(tfields, jfields) {
+		jkeys := slices.Sorted(maps.Keys(jfields))
+		tkeys := slices.Sorted(maps.Keys(tfields))
+		t.Errorf("TSV fields differ from JSON fields:\n%v\n%v", tkeys, jkeys)
+	}
+
+	var xbuf bytes.Buffer
+	if e := WriteOutlierText(&xbuf, r); e != nil {
+		t.Fatal(e)
+	}
+	text := xbuf.String()
+	for _, want := range []string{
+		"biggest outlier percentage: 0.4\n",
+		"gpd tail p: 0.25; KS 0.3 (p 0.9); AD 0.5; converged true\n",
+		"chosenRank 1; ",
+		"candidate 6: found true; ",
+		"candidates used 2 of 2; ",
+		"normalization z; ",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text report missing %q:\n%v", want, text)
+		}
+	}
+}
+
+func TestChosenStatsMissing(t *testing.T) {
+	real, e := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarp), false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	bgs := []BackgroundSummary{
+		{ChosenFound: true, ChosenScore: 0.3, ChosenRank: 0.5},
+		{},
+		{ChosenFound: true, ChosenScore: 0.6, ChosenRank: 0.2},
+		{ChosenFound: true, ChosenScore: 0.05, ChosenRank: 0.9},
+	}
+	c, e := ChosenStats("6", real, bgs, PosteriorScore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c.NBackground != 3 || c.NMissing != 1 || c.Rank != 2.0/3 || c.BackgroundRanks.N != 3 || c.TTest == nil || c.TTest.N != 3 {
+		t.Errorf("chosen %+v", c)
+	}
+	if _, e := ChosenStats("6", real, []BackgroundSummary{{}, {}}, PosteriorScore); e == nil {
+		t.Errorf("no error when no background has the chosen individual")
+	}
+}
+
+func TestChosenStatsSingleBackground(t *testing.T) {
+	real, e := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarp), false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	c, e := ChosenStats("6", real, []BackgroundSummary{{ChosenFound: true, ChosenScore: 0.3, ChosenRank: 0.5}}, PosteriorScore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c.NBackground != 1 || c.Rank != 1 || c.BackgroundRanks.N != 1 || c.TTest != nil || c.TTestError == "" {
+		t.Errorf("chosen %+v", c)
+	}
+
+	// the report is still written in every format
+	r := OutlierReport{Chosen: &c}
+	for _, format := range []string{"text", "json", "tsv"} {
+		var b strings.Builder
+		if e := WriteOutlierReport(&b, format, r); e != nil {
+			t.Errorf("%v: %v", format, e)
+		}
+		if !strings.Contains(b.String(), c.TTestError) {
+			t.Errorf("%v report does not say why the t test was not run:\n%v", format, b.String())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package tdt
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/jgbaldwinbrown/csvh"
+)
+
+// Write each element of vals as an indented JSON object to path, gzipped if path ends in .gz
+func WriteJsonPath[T any](path string, vals []T) (err error) {
+	ww, e := csvh.CreateMaybeGz(path)
+	if e != nil {
+		return e
+	}
+	defer func() { csvh.DeferE(&err, ww.Close()) }()
+	w := bufio.NewWriter(ww)
+	defer func() { csvh.DeferE(&err, w.Flush()) }()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	for _, v := range vals {
+		if e := enc.Encode(v); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Run write on a buffered writer to path, gzipped if path ends in .gz, or to stdout if path is empty
+func WriteOutput(path string, write func(w io.Writer) error) (err error) {
+	var ww io.Writer = os.Stdout
+	if path != "" {
+		wc, e := csvh.CreateMaybeGz(path)
+		if e != nil {
+			return e
+		}
+		defer func() { csvh.DeferE(&err, wc.Close()) }()
+		ww = wc
+	}
+	w := bufio.NewWriter(ww)
+	defer func() { csvh.DeferE(&err, w.Flush()) }()
+	return write(w)
+}
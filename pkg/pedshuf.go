@@ -6,8 +6,10 @@ import (
 	"github.com/jgbaldwinbrown/csvh"
 	"io"
 	"log"
+	"maps"
 	"math/rand"
 	"os"
+	"slices"
 )
 
 // type PedEntry struct {
@@ -26,6 +28,155 @@ func ShufPedSex(ps []PedEntry, r *rand.Rand) {
 	})
 }
 
+// How sexes are allowed to move during a shuffle
+type ShufMode string
+
+const (
+	// Shuffle sexes across the entire pedigree, as ShufPedSex does
+	ShufAll ShufMode = "all"
+	// Shuffle offspring sexes only among full siblings
+	ShufSibship ShufMode = "sibship"
+	// Shuffle sexes only among individuals of the same generation
+	ShufGeneration ShufMode = "generation"
+	// Shuffle sexes only among individuals with the same FamilyID
+	ShufFamily ShufMode = "family"
+	// Shuffle sexes only among individuals that are not parents
+	ShufLeaves ShufMode = "leaves"
+)
+
+// Check that m is a known shuffle mode
+func (m ShufMode) Validate() error {
+	switch m {
+	case ShufAll, ShufSibship, ShufGeneration, ShufFamily, ShufLeaves:
+		return nil
+	}
+	return fmt.Errorf("unknown shuffle mode %q", m)
+}
+
+// Register the -mode flag, which sets mode, with value as its default
+func ShufModeFlag(mode *string, value ShufMode) {
+	flag.StringVar(mode, "mode", string(value), "sex shuffle mode: all, sibship, generation, family, or leaves")
+}
+
+// Find the generation of every individual: 0 for founders, otherwise one more than their latest parent
+func Generations(tree map[string]Node) map[string]int {
+	gens := make(map[string]int, len(tree))
+	var gen func(id string, depth int) int
+	gen = func(id string, depth int) int {
+		if g, ok := gens[id]; ok {
+			return g
+		}
+		node, ok := tree[id]
+		if !ok || depth > len(tree) {
+			return -1
+		}
+		g := 1 + max(gen(node.PaternalID, depth+1), gen(node.MaternalID, depth+1))
+		gens[id] = g
+		return g
+	}
+	for id := range tree {
+		gen(id, 0)
+	}
+	return gens
+}
+
+// Find the sex each parent must have: 1 for fathers and 2 for mothers
+func ParentSexes(ps []PedEntry) map[string]int64 {
+	out := map[string]int64{}
+	for _, p := range ps {
+		if !IsOrphan(p.PaternalID) {
+			out[p.PaternalID] = 1
+		}
+		if !IsOrphan(p.MaternalID) {
+			out[p.MaternalID] = 2
+		}
+	}
+	return out
+}
+
+// Group the indices of ps whose sexes may be swapped with each other under
+// mode. Parents are never in a group, and groups are returned in a fixed
+// order so that seeded shuffles are reproducible.
+func ShufGroups(ps []PedEntry, mode ShufMode) [][]int {
+	parents := ParentSexes(ps)
+	var gens map[string]int
+	if mode == ShufGeneration {
+		gens = Generations(BuildPedTree(ps...))
+	}
+
+	groups := map[string][]int{}
+	for i, p := range ps {
+		if _, ok := parents[p.IndividualID]; ok {
+			continue
+		}
+		var key string
+		switch mode {
+		case ShufSibship:
+			if IsOrphan(p.PaternalID) && IsOrphan(p.MaternalID) {
+				continue
+			}
+			key = p.PaternalID + "\t" + p.MaternalID
+		case ShufGeneration:
+			key = fmt.Sprint(gens[p.IndividualID])
+		case ShufFamily:
+			key = p.FamilyID
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	out := make([][]int, 0, len(groups))
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		out = append(out, groups[key])
+	}
+	return out
+}
+
+// Count the parents in ps whose sex differs from that of their parental role
+func ParentSexConflicts(ps []PedEntry) int {
+	parents := ParentSexes(ps)
+	n := 0
+	for _, p := range ps {
+		if sex, ok := parents[p.IndividualID]; ok && p.Sex != sex {
+			n++
+		}
+	}
+	return n
+}
+
+// Log a warning if shuffling ps under mode will overwrite any parent's sex
+func WarnParentSexConflicts(ps []PedEntry, mode ShufMode) {
+	if mode == ShufAll {
+		return
+	}
+	if n := ParentSexConflicts(ps); n > 0 {
+		log.Printf("warning: %v parents have a sex that conflicts with their parental role; shuffle mode %v gives them the sex of their role", n, mode)
+	}
+}
+
+// Shuffle sexes under mode. Except in ShufAll mode, every parent is given the
+// sex of their parental role and keeps it, so the shuffled pedigree remains
+// biologically possible and every lineage keeps its members. Parents whose
+// recorded sex conflicts with their role (see ParentSexConflicts) are
+// silently corrected, so callers should warn with WarnParentSexConflicts.
+func ShufPedSexMode(ps []PedEntry, r *rand.Rand, mode ShufMode) {
+	if mode == ShufAll {
+		ShufPedSex(ps, r)
+		return
+	}
+	parents := ParentSexes(ps)
+	for i := range ps {
+		if sex, ok := parents[ps[i].IndividualID]; ok {
+			ps[i].Sex = sex
+		}
+	}
+	for _, group := range ShufGroups(ps, mode) {
+		r.Shuffle(len(group), func(i, j int) {
+			a, b := group[i], group[j]
+			ps[a].Sex, ps[b].Sex = ps[b].Sex, ps[a].Sex
+		})
+	}
+}
+
 // aruments; ShufPhenos indicates to shuffle the phenotypes instead of the sexes
 type ShufPedSexFlags struct {
 	Inpath     string
@@ -33,6 +184,9 @@ type ShufPedSexFlags struct {
 	Reps       int
 	Seed       int
 	ShufPhenos bool
+	Mode       string
+	OutputFmt  string
+	Only       int
 }
 
 // Parse ped file (again?)
@@ -91,24 +245,59 @@ func FullShufPedSex() {
 	flag.IntVar(&f.Reps, "r", 1, "shuffle replicates")
 	flag.IntVar(&f.Seed, "s", 0, "random seed")
 	flag.BoolVar(&f.ShufPhenos, "p", false, "huffle phenotype instead of sex")
+	ShufModeFlag(&f.Mode, ShufAll)
+	flag.StringVar(&f.OutputFmt, "wo", "", "WARP output path of each replicate to record in the manifest, with %d for the replicate number (optional)")
+	flag.IntVar(&f.Only, "only", -1, "regenerate only this replicate, without rewriting the manifest")
 	flag.Parse()
+	mode := ShufMode(f.Mode)
+	if e := mode.Validate(); e != nil {
+		log.Fatal(e)
+	}
+	if f.Only >= f.Reps {
+		log.Fatal(fmt.Errorf("-only %v is not below -r %v", f.Only, f.Reps))
+	}
+	if f.OutputFmt != "" {
+		if e := ValidateReplicateFormat(f.OutputFmt); e != nil {
+			log.Fatal(e)
+		}
+	}
 
-	ps, e := ParsePedPathMaybe(f.Inpath)
+	ps, sum, e := ParsePedPathHash(f.Inpath)
 	if e != nil {
 		log.Fatal(e)
 	}
-	ps = UniqPed(ps...)
-
-	r := rand.New(rand.NewSource(int64(f.Seed)))
+	ps = SortedUniqPed(ps...)
+	if !f.ShufPhenos {
+		WarnParentSexConflicts(ps, mode)
+	}
 
+	m := ReplicateManifest{
+		Input:       ManifestInput(f.Inpath),
+		InputSHA256: sum,
+		Seed:        uint64(f.Seed),
+		Mode:        f.Mode,
+		ShufPhenos:  f.ShufPhenos,
+	}
 	for i := 0; i < f.Reps; i++ {
-		if f.ShufPhenos {
-			ShufPedPheno(ps, r)
-		} else {
-			ShufPedSex(ps, r)
+		if f.Only >= 0 && i != f.Only {
+			continue
+		}
+		rep := ManifestReplicate{
+			Replicate: i,
+			Seed:      ReplicateSeed(m.Seed, i),
+			Path:      fmt.Sprintf("%v_%v.ped.gz", f.Outpre, i),
 		}
-		opath := fmt.Sprintf("%v_%v.ped.gz", f.Outpre, i)
-		if e := WritePedPath(opath, ps); e != nil {
+		if f.OutputFmt != "" {
+			rep.Output = fmt.Sprintf(f.OutputFmt, i)
+		}
+		if e := WritePedPath(rep.Path, ShufReplicate(ps, rep.Seed, mode, f.ShufPhenos)); e != nil {
+			log.Fatal(e)
+		}
+		m.Replicates = append(m.Replicates, rep)
+	}
+
+	if f.Only < 0 {
+		if e := WriteManifestPath(f.Outpre+"_manifest.json", m); e != nil {
 			log.Fatal(e)
 		}
 	}
@@ -0,0 +1,90 @@
+package tdt
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestShufPedSexModeKeepsParents(t *testing.T) {
+	for _, mode := range []ShufMode{ShufSibship, ShufGeneration, ShufFamily, ShufLeaves} {
+		ps := SortedUniqPed(scanExamplePed()...)
+		ShufPedSexMode(ps, rand.New(rand.NewSource(1)), mode)
+		parents := ParentSexes(ps)
+		nmales := 0
+		for _, p := range ps {
+			if sex, ok := parents[p.IndividualID]; ok && p.Sex != sex {
+				t.Errorf("mode %v: parent %v has sex %v, expected %v", mode, p.IndividualID, p.Sex, sex)
+			}
+			if p.Sex == 1 {
+				nmales++
+			}
+		}
+		if nmales != 7 {
+			t.Errorf("mode %v: %v males != 7", mode, nmales)
+		}
+	}
+}
+
+func TestShufPedSexModeGroups(t *testing.T) {
+	orig := SortedUniqPed(scanExamplePed()...)
+	for _, mode := range []ShufMode{ShufAll, ShufSibship, ShufGeneration, ShufFamily, ShufLeaves} {
+		groups := ShufGroups(orig, mode)
+		if mode == ShufAll {
+			// every individual, parents included, may move
+			all := make([]int, len(orig))
+			for i := range all {
+				all[i] = i
+			}
+			groups = [][]int{all}
+		}
+		grouped := map[int]bool{}
+		for _, g := range groups {
+			for _, i := range g {
+				grouped[i] = true
+			}
+		}
+
+		moved := false
+		for seed := int64(0); seed < 20; seed++ {
+			ps := slices.Clone(orig)
+			ShufPedSexMode(ps, rand.New(rand.NewSource(seed)), mode)
+			for _, g := range groups {
+				var before, after []int64
+				for _, i := range g {
+					before = append(before, orig[i].Sex)
+					after = append(after, ps[i].Sex)
+				}
+				slices.Sort(before)
+				slices.Sort(after)
+				if !slices.Equal(before, after) {
+					t.Errorf("mode %v, seed %v: group %v sexes %v became %v", mode, seed, g, before, after)
+				}
+			}
+			for i := range ps {
+				if !grouped[i] && ps[i].Sex != orig[i].Sex {
+					t.Errorf("mode %v, seed %v: ungrouped individual %v changed sex", mode, seed, ps[i].IndividualID)
+				}
+				moved = moved || ps[i].Sex != orig[i].Sex
+			}
+		}
+		if !moved {
+			t.Errorf("mode %v: no sex moved in 20 shuffles", mode)
+		}
+	}
+}
+
+func TestParentSexConflicts(t *testing.T) {
+	ps := SortedUniqPed(scanExamplePed()...)
+	if n := ParentSexConflicts(ps); n != 0 {
+		t.Errorf("%v conflicts in a consistent pedigree", n)
+	}
+	for i := range ps {
+		if ps[i].IndividualID == "1" || ps[i].IndividualID == "4" {
+			ps[i].Sex = 3 - ps[i].Sex
+		}
+	}
+	if n := ParentSexConflicts(ps); n != 2 {
+		t.Errorf("%v conflicts != 2 after swapping the sexes of a father and a mother", n)
+	}
+}
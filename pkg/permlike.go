@@ -1,13 +1,20 @@
 package tdt
 
 import (
+	"cmp"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/jgbaldwinbrown/csvh"
 	"github.com/jgbaldwinbrown/iterh"
 	"github.com/montanaflynn/stats"
+	"io"
 	"iter"
 	"log"
+	"math"
+	"runtime"
 	"slices"
+	"sort"
 )
 
 // Get a mean for each float slice
@@ -22,11 +29,16 @@ func Means(it iter.Seq[[]float64]) iter.Seq2[float64, error] {
 	}
 }
 
-// Find the value that corresponds to this percentile in the distribution "fs"
+// Find the value that corresponds to this percentile in the distribution
+// "fs". Perc is clamped to [0, 1], so perc=1 gives the largest value. An
+// empty distribution gives NaN.
 func Quantile(fs []float64, perc float64) float64 {
+	if len(fs) < 1 {
+		return math.NaN()
+	}
 	sorted := slices.Sorted(slices.Values(fs))
 	quant := int(perc * float64(len(fs)))
-	return sorted[quant]
+	return sorted[min(max(quant, 0), len(sorted)-1)]
 }
 
 // Get a slice of posteriors for every set of entries. Bufsiz is optional and
@@ -70,120 +82,302 @@ func FlattenEntries(itit iter.Seq[iter.Seq2[Entry, error]], ep *error) iter.Seq[
 	}
 }
 
-// Run on the command line
-func RunCountWARPHits() {
-	var f Flags
-	flag.StringVar(&f.RealPath, "r", "", "Path to output of warp for real data")
-	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data")
-	flag.BoolVar(&f.RealHeader, "rh", false, "Real data has a header line")
-	flag.BoolVar(&f.BgHeader, "bh", false, "Background data has a header line")
-
-	flag.Parse()
-	if f.RealPath == "" {
-		log.Fatal("missing -r")
-	}
-	if f.BgPathsPath == "" {
-		log.Fatal("missing -b")
-	}
+// How to build the null distribution from background WARP files
+type PermlikeNull string
 
-	realEntries, e := iterh.CollectWithError(ParsePedPath(f.RealPath, f.RealHeader))
-	if e != nil {
-		log.Fatal(e)
-	}
+const (
+	NullMean   PermlikeNull = "mean"   // the mean score of each background pedigree
+	NullMax    PermlikeNull = "max"    // the largest score of each background pedigree
+	NullPooled PermlikeNull = "pooled" // every individual of every background pedigree
+)
 
-	bgPaths := slices.Collect(iterh.BreakOnError(iterh.PathIter(f.BgPathsPath, iterh.LineIter), &e))
-	if e != nil {
-		log.Fatal(e)
-	}
+// Whether individual scores can be compared with the null. The mean null
+// holds pedigree means, which are far less variable than single scores, so
+// it only tests the real pedigree's mean.
+func (n PermlikeNull) PerIndividual() bool {
+	return n != NullMean
+}
 
-	realLikelihoods := slices.Collect(Posteriors(slices.Values(realEntries)))
-	realMeanLikelihood, e := stats.Mean(realLikelihoods)
-	if e != nil {
-		log.Fatal(e)
+func (n PermlikeNull) Validate() error {
+	switch n {
+	case NullMean, NullMax, NullPooled:
+		return nil
 	}
+	return fmt.Errorf("unknown null %q; choose mean, max or pooled", string(n))
+}
 
-	bufsiz := int(float64(len(realEntries)) * 1.25)
-	bgPosteriorSlices, e := iterh.CollectWithError(PosteriorSlices(bufsiz, ParsePedPaths(f.BgHeader, bgPaths...)))
+// Read the null distribution from the background files, also returning the
+// number of background entries skipped because their score was not finite
+func PermlikeNullDist(bgPaths []string, null PermlikeNull, opt BackgroundOptions, nworkers int) ([]float64, int, error) {
+	opt.KeepScores = null == NullPooled
+	bgs, e := SummarizeBackgrounds(bgPaths, opt, nworkers)
 	if e != nil {
-		log.Fatal(e)
+		return nil, 0, e
 	}
-	bgPosteriorMeans, e := iterh.CollectWithError(Means(slices.Values(bgPosteriorSlices)))
-	if e != nil {
-		log.Fatal(e)
+	var out []float64
+	for _, bg := range bgs {
+		switch null {
+		case NullMean:
+			out = append(out, bg.Mean)
+		case NullMax:
+			out = append(out, bg.Max().Score)
+		case NullPooled:
+			out = append(out, bg.Scores...)
+		}
 	}
+	return out, SkippedScores(bgs), nil
+}
 
-	significanceThresh := Quantile(bgPosteriorMeans, 0.95)
-	fmt.Printf("likelihood significance threshold: %v\n", significanceThresh)
-	fmt.Printf("actual mean likelihood: %v\n", realMeanLikelihood)
-	fmt.Printf("significant: %v\n", realMeanLikelihood > significanceThresh)
+// The null distribution's value at one quantile, and how many real
+// individuals score above it (none under NullMean, which does not test them)
+type PermlikeThreshold struct {
+	Quantile     float64
+	Threshold    float64
+	NSignificant int
+}
 
-	// var sigs []Entry
-	// for _, entry := range realEntries {
-	// 	if entry.Posterior > significanceThresh {
-	// 		sigs = append(sigs, entry)
-	// 	}
-	// }
-	// fmt.Printf("num significant: %v\n", len(sigs))
-	// fmt.Printf("significant individuals:\n")
-	// for _, entry := range sigs {
-	// 	fmt.Printf("%#v", entry)
-	// }
+// One real individual compared to the null. EmpiricalP is the fraction of
+// null values at least as large as Score, with +1 correction. SignificantAt
+// lists the quantiles whose thresholds Score exceeds. TailP is the p-value
+// extrapolated from a fitted tail model, or EmpiricalP where the model does
+// not apply (below a GPD threshold).
+type PermlikeIndividual struct {
+	FamilyID      string
+	IndividualID  string
+	Score         float64
+	EmpiricalP    float64
+	TailP         *float64 `json:",omitempty"`
+	SignificantAt []float64
 }
 
-// Run on the command line, v2
-func RunCountWARPHits2() {
-	var f Flags
-	flag.StringVar(&f.RealPath, "r", "", "Path to output of warp for real data")
-	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data")
-	flag.BoolVar(&f.RealHeader, "rh", false, "Real data has a header line")
-	flag.BoolVar(&f.BgHeader, "bh", false, "Background data has a header line")
+// The result of the permlike command. Observed is the real pedigree's
+// statistic of the same kind as the null: its mean score for the mean null,
+// and its largest score otherwise. ObservedP is its empirical p-value, and
+// ObservedTailP its p-value under Tail, if a tail model was fitted.
+// RealSkipped and NullSkipped count the real and background entries left out
+// because their score was not finite (see FiniteScore).
+type PermlikeReport struct {
+	Null          string
+	Score         string
+	NullCount     int
+	RealSkipped   int
+	NullSkipped   int
+	Observed      float64
+	ObservedP     float64
+	Tail          *TailFit `json:",omitempty"`
+	ObservedTailP *float64 `json:",omitempty"`
+	Thresholds    []PermlikeThreshold
+	Individuals   []PermlikeIndividual
+}
 
-	flag.Parse()
-	if f.RealPath == "" {
-		log.Fatal("missing -r")
-	}
-	if f.BgPathsPath == "" {
-		log.Fatal("missing -b")
+// Fraction of sorted values at least as large as x, with +1 correction
+func upperEmpiricalP(sorted []float64, x float64) float64 {
+	nbelow := sort.SearchFloat64s(sorted, x)
+	return float64(len(sorted)-nbelow+1) / float64(len(sorted)+1)
+}
+
+// Check that a tail model suits a null: extreme value fits need per-pedigree
+// maxima, and GPD fits need the pooled values
+func ValidatePermlikeTail(null PermlikeNull, tail TailModel) error {
+	switch {
+	case tail == TailNone:
+		return nil
+	case (tail == TailGumbel || tail == TailGEV) && null == NullMax:
+		return nil
+	case tail == TailGPD && null == NullPooled:
+		return nil
 	}
+	return fmt.Errorf("tail model %q does not fit null %q; use gumbel or gev with max, and gpd with pooled", string(tail), string(null))
+}
 
-	realEntries, e := iterh.CollectWithError(ParsePedPath(f.RealPath, f.RealHeader))
+// Fit a tail model to the null distribution and add tail p-values to the
+// report. ThresholdQ is the null quantile above which a GPD is fitted.
+func (r *PermlikeReport) AddTail(nullDist []float64, tail TailModel, thresholdQ float64) error {
+	fit, e := FitTail(tail, nullDist, thresholdQ)
 	if e != nil {
-		log.Fatal(e)
+		return fmt.Errorf("AddTail: %w", e)
+	}
+	r.Tail = &fit
+	tailP := func(x, empirical float64) *float64 {
+		p, ok := fit.P(x)
+		if !ok {
+			p = empirical
+		}
+		return &p
 	}
+	r.ObservedTailP = tailP(r.Observed, r.ObservedP)
+	for i, ind := range r.Individuals {
+		r.Individuals[i].TailP = tailP(ind.Score, ind.EmpiricalP)
+	}
+	return nil
+}
 
-	bgPaths := slices.Collect(iterh.BreakOnError(iterh.PathIter(f.BgPathsPath, iterh.LineIter), &e))
-	if e != nil {
-		log.Fatal(e)
+// Compare real individuals to a null distribution. Only individuals that
+// exceed at least one threshold are listed, unless all is set. Under
+// NullMean, only Observed is tested: no individuals are listed and no
+// threshold counts any as significant (see PerIndividual).
+func Permlike(realEntries []Entry, nullDist []float64, null PermlikeNull, score ScoreFunc, quantiles []float64, all bool) (PermlikeReport, error) {
+	r := PermlikeReport{Null: string(null), NullCount: len(nullDist), Individuals: []PermlikeIndividual{}}
+	if len(nullDist) < 1 {
+		return r, fmt.Errorf("Permlike: empty null distribution")
 	}
+	sorted := slices.Sorted(slices.Values(nullDist))
 
-	bgHighestPosterior, e := iterh.CollectWithError(GetBiggestOutlierPaths(slices.Values(bgPaths), f.BgHeader))
+	realEntries, r.RealSkipped = FiniteEntries(realEntries, score)
+	realScores := slices.Collect(Scores(slices.Values(realEntries), score))
+	var e error
+	if null == NullMean {
+		r.Observed, e = stats.Mean(realScores)
+	} else {
+		r.Observed, e = stats.Max(realScores)
+	}
 	if e != nil {
-		log.Fatal(e)
+		return r, fmt.Errorf("Permlike: %w", e)
+	}
+	r.ObservedP = upperEmpiricalP(sorted, r.Observed)
+
+	for _, q := range quantiles {
+		r.Thresholds = append(r.Thresholds, PermlikeThreshold{Quantile: q, Threshold: Quantile(sorted, q)})
+	}
+	if !null.PerIndividual() {
+		return r, nil
+	}
+	for i, ent := range realEntries {
+		ind := PermlikeIndividual{
+			FamilyID:     ent.FamilyID,
+			IndividualID: ent.IndividualID,
+			Score:        realScores[i],
+			EmpiricalP:   upperEmpiricalP(sorted, realScores[i]),
+		}
+		for j, t := range r.Thresholds {
+			if ind.Score > t.Threshold {
+				ind.SignificantAt = append(ind.SignificantAt, t.Quantile)
+				r.Thresholds[j].NSignificant++
+			}
+		}
+		if all || len(ind.SignificantAt) > 0 {
+			r.Individuals = append(r.Individuals, ind)
+		}
 	}
+	slices.SortStableFunc(r.Individuals, func(a, b PermlikeIndividual) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return r, nil
+}
 
-	significanceThresh := Quantile(slices.Collect(Posteriors(slices.Values(bgHighestPosterior))), 0.95)
-	fmt.Printf("likelihood significance threshold: %v\n", significanceThresh)
+// Write the report as human-readable lines
+func WritePermlikeText(w io.Writer, r PermlikeReport) error {
+	if _, e := fmt.Fprintf(w, "null %v of %v background values; observed %v; empirical p %v\n", r.Null, r.NullCount, r.Observed, r.ObservedP); e != nil {
+		return e
+	}
+	if r.RealSkipped > 0 || r.NullSkipped > 0 {
+		if _, e := fmt.Fprintf(w, "skipped non-finite scores: real %v; background %v\n", r.RealSkipped, r.NullSkipped); e != nil {
+			return e
+		}
+	}
+	if t := r.Tail; t != nil && r.ObservedTailP != nil {
+		if _, e := fmt.Fprintf(w, "%v tail p: %v; KS %v (p %v); AD %v; converged %v\n", t.Model, *r.ObservedTailP, t.Fit.KS, t.Fit.KSP, t.Fit.AD, t.Converged); e != nil {
+			return e
+		}
+	}
+	for _, t := range r.Thresholds {
+		if !PermlikeNull(r.Null).PerIndividual() {
+			if _, e := fmt.Fprintf(w, "quantile %v: likelihood significance threshold %v; observed mean above %v\n", t.Quantile, t.Threshold, r.Observed > t.Threshold); e != nil {
+				return e
+			}
+			continue
+		}
+		if _, e := fmt.Fprintf(w, "quantile %v: likelihood significance threshold %v; num significant %v\n", t.Quantile, t.Threshold, t.NSignificant); e != nil {
+			return e
+		}
+	}
+	for _, ind := range r.Individuals {
+		if _, e := fmt.Fprintf(w, "%v\t%v\t%v\t%v", ind.FamilyID, ind.IndividualID, ind.Score, ind.EmpiricalP); e != nil {
+			return e
+		}
+		if ind.TailP != nil {
+			if _, e := fmt.Fprintf(w, "\t%v", *ind.TailP); e != nil {
+				return e
+			}
+		}
+		if _, e := fmt.Fprintln(w); e != nil {
+			return e
+		}
+	}
+	return nil
+}
 
-	var sigs []Entry
-	for _, entry := range realEntries {
-		if entry.Posterior > significanceThresh {
-			sigs = append(sigs, entry)
+// Write one line per listed individual, with one column per quantile saying whether its threshold was exceeded
+func WritePermlikeTSV(w io.Writer, r PermlikeReport) error {
+	cw := csvh.CsvOut(w)
+	header := []string{"FamilyID", "IndividualID", "Score", "EmpiricalP"}
+	if r.Tail != nil {
+		header = append(header, "TailP")
+	}
+	for _, t := range r.Thresholds {
+		header = append(header, fmt.Sprintf("Above%v", t.Quantile))
+	}
+	if e := cw.Write(header); e != nil {
+		return e
+	}
+	var line []string
+	for _, ind := range r.Individuals {
+		line = append(line[:0], ind.FamilyID, ind.IndividualID, fmt.Sprint(ind.Score), fmt.Sprint(ind.EmpiricalP))
+		if r.Tail != nil && ind.TailP != nil {
+			line = append(line, fmt.Sprint(*ind.TailP))
+		}
+		for _, t := range r.Thresholds {
+			line = append(line, fmt.Sprint(slices.Contains(ind.SignificantAt, t.Quantile)))
+		}
+		if e := cw.Write(line); e != nil {
+			return e
 		}
 	}
-	fmt.Printf("num significant: %v\n", len(sigs))
-	fmt.Printf("significant individuals:\n")
-	for _, entry := range sigs {
-		fmt.Printf("%#v", entry)
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write a permlike report in format "text", "json" or "tsv"
+func WritePermlikeReport(w io.Writer, format string, r PermlikeReport) error {
+	switch format {
+	case "text":
+		return WritePermlikeText(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(r)
+	case "tsv":
+		return WritePermlikeTSV(w, r)
+	default:
+		return fmt.Errorf("WritePermlikeReport: unknown format %q", format)
 	}
 }
 
-// Run on the command line, v3
-func RunCountWARPHits3() {
-	var f Flags
+// Flags for RunPermlike
+type PermlikeFlags struct {
+	Flags
+	Null      string
+	Quantiles string
+	All       bool
+	TailQ     float64
+}
+
+// Run on the command line
+func RunPermlike() {
+	var f PermlikeFlags
 	flag.StringVar(&f.RealPath, "r", "", "Path to output of warp for real data")
-	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data")
+	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data, or a pedshufsex replicate manifest")
 	flag.BoolVar(&f.RealHeader, "rh", false, "Real data has a header line")
 	flag.BoolVar(&f.BgHeader, "bh", false, "Background data has a header line")
+	flag.StringVar(&f.Score, "score", "posterior", "WARP column or derived score to rank individuals by: "+ScoreFuncNames())
+	flag.IntVar(&f.Workers, "w", runtime.NumCPU(), "Number of background files to read in parallel")
+	flag.StringVar(&f.Null, "null", "mean", "Null distribution: mean or max of each background pedigree, or pooled individuals")
+	flag.StringVar(&f.Quantiles, "q", "0.95", "Comma-separated null quantiles to use as significance thresholds")
+	flag.BoolVar(&f.All, "all", false, "List every real individual, not only significant ones")
+	flag.StringVar(&f.Tail, "tail", "none", "Fit a tail model to the null and report extrapolated p-values: none, gumbel or gev (with -null max), or gpd (with -null pooled)")
+	flag.Float64Var(&f.TailQ, "tu", 0.9, "Null quantile above which -tail gpd is fitted")
+	flag.StringVar(&f.Format, "f", "text", "Output format: text, json or tsv")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write output (default stdout)")
 
 	flag.Parse()
 	if f.RealPath == "" {
@@ -192,33 +386,51 @@ func RunCountWARPHits3() {
 	if f.BgPathsPath == "" {
 		log.Fatal("missing -b")
 	}
+	null := PermlikeNull(f.Null)
+	Must(null.Validate())
+	tail := TailModel(f.Tail)
+	Must(tail.Validate())
+	Must(ValidatePermlikeTail(null, tail))
+	if f.All && !null.PerIndividual() {
+		log.Fatal(fmt.Errorf("-all lists individuals, which -null mean does not test; use -null max or pooled"))
+	}
+	if f.TailQ < 0 || f.TailQ >= 1 {
+		log.Fatal(fmt.Errorf("-tu %v is not in [0, 1)", f.TailQ))
+	}
+	quantiles, e := ParseFloatList(f.Quantiles)
+	Must(e)
+	for _, q := range quantiles {
+		if q < 0 || q >= 1 {
+			log.Fatal(fmt.Errorf("quantile %v is not in [0, 1)", q))
+		}
+	}
+	score, e := ParseScoreFunc(f.Score)
+	Must(e)
 
 	realEntries, e := iterh.CollectWithError(ParsePedPath(f.RealPath, f.RealHeader))
 	if e != nil {
 		log.Fatal(e)
 	}
 
-	bgPaths := slices.Collect(iterh.BreakOnError(iterh.PathIter(f.BgPathsPath, iterh.LineIter), &e))
+	bgPaths, e := BackgroundPaths(f.BgPathsPath)
 	if e != nil {
 		log.Fatal(e)
 	}
 
-	bgEntries := FlattenEntries(ParsePedPaths(f.BgHeader, bgPaths...), &e)
-	significanceThresh := Quantile(slices.Collect(Posteriors(bgEntries)), 0.95)
+	nullDist, nskipped, e := PermlikeNullDist(bgPaths, null, BackgroundOptions{Header: f.BgHeader, Score: score}, f.Workers)
 	if e != nil {
 		log.Fatal(e)
 	}
-	fmt.Printf("likelihood significance threshold: %v\n", significanceThresh)
-
-	var sigs []Entry
-	for _, entry := range realEntries {
-		if entry.Posterior > significanceThresh {
-			sigs = append(sigs, entry)
-		}
+	report, e := Permlike(realEntries, nullDist, null, score, quantiles, f.All)
+	if e != nil {
+		log.Fatal(e)
 	}
-	fmt.Printf("num significant: %v\n", len(sigs))
-	fmt.Printf("significant individuals:\n")
-	for _, entry := range sigs {
-		fmt.Printf("%#v", entry)
+	report.NullSkipped = nskipped
+	report.Score = f.Score
+	if tail != TailNone {
+		Must(report.AddTail(nullDist, tail, f.TailQ))
 	}
+	Must(WriteOutput(f.OutPath, func(w io.Writer) error {
+		return WritePermlikeReport(w, f.Format, report)
+	}))
 }
@@ -0,0 +1,139 @@
+package tdt
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+// Background WARP files in which individual 6 has posterior 0.3, 0.4, 0.5,
+// 0.6 and 0.7, and real entries in which 3 has 0.65 and 6 has 0.46
+func permlikeFixture(t *testing.T) ([]Entry, []string) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("bg%v.txt", i))
+		bg := strings.ReplaceAll(exampleWarp, "0.3\t0.1\t", fmt.Sprintf("0.3\t%v\t", 0.3+float64(i)/10))
+		if e := os.WriteFile(path, []byte(bg), 0644); e != nil {
+			t.Fatal(e)
+		}
+		paths = append(paths, path)
+	}
+	real := strings.ReplaceAll(exampleWarp, "0.3\t0.1\t", "0.3\t0.46\t")
+	real = strings.Replace(real, "1\t3\t1\t2\t1\t1\t\t0.3\t0.5\t", "1\t3\t1\t2\t1\t1\t\t0.3\t0.65\t", 1)
+	ents, e := iterh.CollectWithError(ParsePed(strings.NewReader(real), false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return ents, paths
+}
+
+func permlikeIDs(r PermlikeReport) []string {
+	var ids []string
+	for _, ind := range r.Individuals {
+		ids = append(ids, ind.IndividualID)
+	}
+	return ids
+}
+
+func TestPermlikeMean(t *testing.T) {
+	real, paths := permlikeFixture(t)
+	null, _, e := PermlikeNullDist(paths, NullMean, BackgroundOptions{}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// background means are 2.9/7 to 3.3/7 in steps of 0.1/7
+	if len(null) != 5 {
+		t.Fatalf("null %v", null)
+	}
+	for i, v := range null {
+		if want := (2.9 + float64(i)/10) / 7; math.Abs(v-want) > 1e-12 {
+			t.Errorf("null mean %v: %v != %v", i, v, want)
+		}
+	}
+
+	r, e := Permlike(real, null, NullMean, PosteriorScore, []float64{0.5}, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the real mean 3.21/7 is exceeded only by 3.3/7
+	if math.Abs(r.Observed-3.21/7) > 1e-12 || math.Abs(r.ObservedP-2.0/6) > 1e-12 {
+		t.Errorf("observed %v, p %v", r.Observed, r.ObservedP)
+	}
+	if math.Abs(r.Thresholds[0].Threshold-3.1/7) > 1e-12 || r.Thresholds[0].NSignificant != 0 {
+		t.Errorf("threshold %+v", r.Thresholds[0])
+	}
+	if len(r.Individuals) != 0 {
+		t.Errorf("individuals listed under the mean null: %v", permlikeIDs(r))
+	}
+}
+
+func TestPermlikeMax(t *testing.T) {
+	real, paths := permlikeFixture(t)
+	null, _, e := PermlikeNullDist(paths, NullMax, BackgroundOptions{}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := []float64{0.5, 0.5, 0.5, 0.6, 0.7}; !slices.Equal(null, want) {
+		t.Fatalf("null %v != %v", null, want)
+	}
+
+	r, e := Permlike(real, null, NullMax, PosteriorScore, []float64{0.5}, false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r.Observed != 0.65 || r.ObservedP != 2.0/6 {
+		t.Errorf("observed %v, p %v", r.Observed, r.ObservedP)
+	}
+	if r.Thresholds[0].Threshold != 0.5 || r.Thresholds[0].NSignificant != 1 {
+		t.Errorf("threshold %+v", r.Thresholds[0])
+	}
+	if len(r.Individuals) != 1 {
+		t.Fatalf("individuals %v", permlikeIDs(r))
+	}
+	if ind := r.Individuals[0]; ind.IndividualID != "3" || ind.EmpiricalP != 2.0/6 || !slices.Equal(ind.SignificantAt, []float64{0.5}) {
+		t.Errorf("individual %+v", ind)
+	}
+
+	all, e := Permlike(real, null, NullMax, PosteriorScore, []float64{0.5}, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if ids := permlikeIDs(all); len(ids) != 7 || ids[0] != "3" || ids[6] != "5" {
+		t.Errorf("all individuals %v; expected 7 sorted by score", ids)
+	}
+}
+
+func TestPermlikePooled(t *testing.T) {
+	real, paths := permlikeFixture(t)
+	null, _, e := PermlikeNullDist(paths, NullPooled, BackgroundOptions{}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// each file has four 0.5s, a 0.4 and a 0.2, plus 6's 0.3 to 0.7
+	if len(null) != 35 {
+		t.Fatalf("null has %v values", len(null))
+	}
+
+	r, e := Permlike(real, null, NullPooled, PosteriorScore, []float64{0.5}, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if r.Observed != 0.65 || r.ObservedP != 2.0/36 {
+		t.Errorf("observed %v, p %v", r.Observed, r.ObservedP)
+	}
+	if r.Thresholds[0].Threshold != 0.5 || r.Thresholds[0].NSignificant != 1 {
+		t.Errorf("threshold %+v", r.Thresholds[0])
+	}
+	// 23 null values are at least 0.46: 21 of 0.5, 0.6 and 0.7
+	i := slices.Index(permlikeIDs(r), "6")
+	if i == -1 || r.Individuals[i].EmpiricalP != 24.0/36 {
+		t.Errorf("individual 6 missing or p != 24/36: %+v", r.Individuals)
+	}
+}
@@ -0,0 +1,200 @@
+package tdt
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/montanaflynn/stats"
+	"golang.org/x/exp/rand"
+)
+
+// Score a whole pedigree with one number. Larger scores are more extreme.
+// Every scorer here reads only sexes, so permutations shuffle sexes; other
+// statistics can be added to PedScorers as long as they do the same.
+type PedScorer func(p *IndexedPed) float64
+
+// Score a pedigree by the most significant TDT test among all lineages of one kind, as -log10(P)
+func MinPScorer(kind LineageKind, x ExpectedRatio) PedScorer {
+	return func(p *IndexedPed) float64 {
+		minp := 1.0
+		for _, res := range TDTScan(p, kind, x) {
+			if res.P < minp {
+				minp = res.P
+			}
+		}
+		return -math.Log10(minp)
+	}
+}
+
+// Score a pedigree by the largest lineage scan statistic over all lineage kinds
+func ScanScorer(kinds ...LineageKind) PedScorer {
+	return func(p *IndexedPed) float64 {
+		return MaxLLR(p, kinds...)
+	}
+}
+
+// Build the scorers that can be chosen by name on the command line
+func PedScorers(x ExpectedRatio) map[string]PedScorer {
+	return map[string]PedScorer{
+		"ytdt":    MinPScorer(LineageY, x),
+		"xtdt":    MinPScorer(LineageX, x),
+		"autotdt": MinPScorer(LineageAuto, x),
+		"scan":    ScanScorer(LineageY, LineageX, LineageAuto),
+	}
+}
+
+// One replicate's score
+type ReplicateScore struct {
+	Replicate int
+	Score     float64
+}
+
+// Null scores from all replicates, in replicate order after merging
+type ScoreAccumulator struct {
+	Scores []ReplicateScore
+}
+
+func (a *ScoreAccumulator) Merge(b *ScoreAccumulator) {
+	a.Scores = append(a.Scores, b.Scores...)
+	slices.SortFunc(a.Scores, func(x, y ReplicateScore) int {
+		return cmp.Compare(x.Replicate, y.Replicate)
+	})
+}
+
+// Shuffle the sexes in ped nreps times under mode and score each replicate.
+// Ped should be sorted, as by SortedUniqPed, for the result to be
+// reproducible.
+func PermutationScores(ped []PedEntry, scorer PedScorer, mode ShufMode, seed uint64, nreps, nworkers int) []ReplicateScore {
+	acc := RunReplicates(seed, nreps, nworkers,
+		func() *ScoreAccumulator {
+			return &ScoreAccumulator{}
+		},
+		func(acc *ScoreAccumulator, i int, src rand.Source) {
+			shuf := slices.Clone(ped)
+			ShufPedSexMode(shuf, MathRand(src), mode)
+			score := scorer(NewIndexedPed(BuildPedTree(shuf...)))
+			acc.Scores = append(acc.Scores, ReplicateScore{Replicate: i, Score: score})
+		},
+		(*ScoreAccumulator).Merge,
+	)
+	return acc.Scores
+}
+
+// A value of the null distribution at one quantile
+type QuantileValue struct {
+	Quantile float64
+	Value    float64
+}
+
+// Summary of the null distribution of a pedigree score, compared to the real pedigree's score
+type NullSummary struct {
+	Scorer        string
+	Mode          string
+	Observed      float64
+	Replicates    int
+	NullAsExtreme int
+	EmpiricalP    float64
+	NullMean      float64
+	NullSD        float64
+	NullQuantiles []QuantileValue
+}
+
+// Summarize null scores. The empirical p-value counts null scores at least as large as observed, with +1 correction.
+func SummarizeNull(observed float64, scores []ReplicateScore, quantiles []float64) (NullSummary, error) {
+	s := NullSummary{Observed: observed, Replicates: len(scores)}
+	vals := make([]float64, 0, len(scores))
+	for _, sc := range scores {
+		vals = append(vals, sc.Score)
+		if sc.Score >= observed {
+			s.NullAsExtreme++
+		}
+	}
+	s.EmpiricalP = float64(s.NullAsExtreme+1) / float64(len(scores)+1)
+	if len(vals) < 1 {
+		return s, nil
+	}
+
+	var e error
+	if s.NullMean, e = stats.Mean(vals); e != nil {
+		return s, e
+	}
+	if s.NullSD, e = stats.StandardDeviation(vals); e != nil {
+		return s, e
+	}
+	for _, q := range quantiles {
+		s.NullQuantiles = append(s.NullQuantiles, QuantileValue{Quantile: q, Value: Quantile(vals, q)})
+	}
+	return s, nil
+}
+
+// Flags for FullPermPipeline
+type PermPipelineFlags struct {
+	PedPath   string
+	OutPath   string
+	NullPath  string
+	Scorer    string
+	Mode      string
+	Quantiles string
+	Reps      int
+	Seed      int
+	Workers   int
+	Expected  ExpectedRatio
+}
+
+// Shuffle a pedigree in memory, score every replicate, and write only the null distribution summary
+func FullPermPipeline() {
+	var f PermPipelineFlags
+	flag.StringVar(&f.PedPath, "i", "", "path to input .ped file")
+	flag.StringVar(&f.OutPath, "o", "", "path to write the null distribution summary")
+	flag.StringVar(&f.NullPath, "no", "", "path to write every replicate's score (optional)")
+	flag.StringVar(&f.Scorer, "score", "ytdt", "scoring step: "+strings.Join(slices.Sorted(maps.Keys(PedScorers(ExpectedRatio{}))), ", "))
+	ShufModeFlag(&f.Mode, ShufGeneration)
+	flag.StringVar(&f.Quantiles, "q", "0.5,0.95,0.99", "comma-separated null quantiles to report")
+	flag.IntVar(&f.Reps, "r", 1000, "shuffle replicates")
+	flag.IntVar(&f.Seed, "s", 0, "random seed")
+	ReplicateWorkersFlag(&f.Workers)
+	RegisterExpectedRatioFlags(&f.Expected)
+	flag.Parse()
+	if f.PedPath == "" {
+		log.Fatal(fmt.Errorf("missing -i"))
+	}
+	if f.OutPath == "" {
+		log.Fatal(fmt.Errorf("missing -o"))
+	}
+	Must(f.Expected.Validate())
+	mode := ShufMode(f.Mode)
+	Must(mode.Validate())
+	scorer, ok := PedScorers(f.Expected)[f.Scorer]
+	if !ok {
+		log.Fatal(fmt.Errorf("unknown scoring step %q", f.Scorer))
+	}
+	quantiles, e := ParseFloatList(f.Quantiles)
+	Must(e)
+
+	r, e := csvh.OpenMaybeGz(f.PedPath)
+	Must(e)
+	peds, e := ParsePedSafe(r)
+	r.Close()
+	Must(e)
+	peds = SortedUniqPed(peds...)
+	WarnParentSexConflicts(peds, mode)
+
+	observed := scorer(NewIndexedPed(BuildPedTree(peds...)))
+	scores := PermutationScores(peds, scorer, mode, uint64(f.Seed), f.Reps, f.Workers)
+	summary, e := SummarizeNull(observed, scores, quantiles)
+	Must(e)
+	summary.Scorer = f.Scorer
+	summary.Mode = f.Mode
+
+	Must(WriteJsonPath(f.OutPath, []NullSummary{summary}))
+	if f.NullPath != "" {
+		Must(WriteJsonPath(f.NullPath, scores))
+	}
+}
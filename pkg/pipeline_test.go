@@ -0,0 +1,55 @@
+package tdt
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPermutationScoresWorkers(t *testing.T) {
+	ped := SortedUniqPed(scanExamplePed()...)
+	scorer := MinPScorer(LineageY, ExpectedRatio{MaleProb: 0.5})
+	one := PermutationScores(ped, scorer, ShufGeneration, 5, 40, 1)
+	four := PermutationScores(ped, scorer, ShufGeneration, 5, 40, 4)
+	if !reflect.DeepEqual(one, four) {
+		t.Errorf("scores with 1 worker %v != 4 workers %v", one, four)
+	}
+	for i, s := range one {
+		if s.Replicate != i {
+			t.Errorf("score %v is for replicate %v", i, s.Replicate)
+		}
+	}
+	other := PermutationScores(ped, scorer, ShufGeneration, 6, 40, 4)
+	if reflect.DeepEqual(one, other) {
+		t.Errorf("seeds 5 and 6 gave the same scores")
+	}
+}
+
+func TestSummarizeNull(t *testing.T) {
+	var scores []ReplicateScore
+	for i, v := range []float64{4, 9, 1, 7, 3, 8, 2, 6, 5} {
+		scores = append(scores, ReplicateScore{Replicate: i, Score: v})
+	}
+	s, e := SummarizeNull(7, scores, []float64{0.1, 0.5, 0.9})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Replicates != 9 || s.NullAsExtreme != 3 || s.EmpiricalP != 0.4 {
+		t.Errorf("summary %#v; expected 3 of 9 null scores at least 7 and p 0.4", s)
+	}
+	if s.NullMean != 5 || math.Abs(s.NullSD-math.Sqrt(60.0/9.0)) > 1e-12 {
+		t.Errorf("null mean %v, sd %v", s.NullMean, s.NullSD)
+	}
+	expect := []QuantileValue{{0.1, 1}, {0.5, 5}, {0.9, 9}}
+	if !reflect.DeepEqual(s.NullQuantiles, expect) {
+		t.Errorf("quantiles %v != expect %v", s.NullQuantiles, expect)
+	}
+
+	empty, e := SummarizeNull(7, nil, []float64{0.5})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if empty.EmpiricalP != 1 || empty.NullQuantiles != nil {
+		t.Errorf("summary without replicates %#v", empty)
+	}
+}
@@ -1,96 +1,200 @@
 package tdt
 
 import (
+	"cmp"
+	"encoding/json"
 	"os"
 	"slices"
 	"iter"
 	"strconv"
+	"strings"
 	"fmt"
 	"log"
 	"bufio"
 	"flag"
+	"io"
+	"runtime"
+	"maps"
 
-	"github.com/jgbaldwinbrown/iterh"
+	"github.com/jgbaldwinbrown/csvh"
 )
 
-// ind all relatives within dist steps from focalID along the tree. The outer slice is the distance.
-func Relatives(tree map[string]Node, focalID string, dist int) [][]string {
-	ret := make([][]string, dist + 1)
-	found := map[string]struct{}{}
-	ret[0] = append(ret[0], focalID)
-
-	for d := 1; d < dist + 1; d++ {
-		neighbors := []string{}
-		for _, looking := range ret[d - 1] {
-			node := tree[looking]
-			if node.PaternalID != "0" && node.PaternalID != "999999" {
-				neighbors = append(neighbors, node.PaternalID)
-			}
-			if node.MaternalID != "0" && node.MaternalID != "999999" {
-				neighbors = append(neighbors, node.MaternalID)
+// A disjoint-set forest over integer indices, with union by size and path halving
+type disjointSet struct {
+	parent []int
+	size   []int
+}
+
+func newDisjointSet(n int) *disjointSet {
+	d := &disjointSet{parent: make([]int, n), size: make([]int, n)}
+	for i := range d.parent {
+		d.parent[i] = i
+		d.size[i] = 1
+	}
+	return d
+}
+
+func (d *disjointSet) find(i int) int {
+	for d.parent[i] != i {
+		d.parent[i] = d.parent[d.parent[i]]
+		i = d.parent[i]
+	}
+	return i
+}
+
+func (d *disjointSet) union(i, j int) {
+	i, j = d.find(i), d.find(j)
+	if i == j {
+		return
+	}
+	if d.size[i] < d.size[j] {
+		i, j = j, i
+	}
+	d.parent[j] = i
+	d.size[i] += d.size[j]
+}
+
+// One cluster of relatives: the focal individuals in it, and every
+// individual within the step limit of one of them, both sorted by ID
+type RelativeCluster struct {
+	Size    int
+	NFocal  int
+	Focals  []string
+	Members []string
+}
+
+// Cluster the focal individuals at indices focals, with every individual
+// within dist steps of a focal, so that focals whose sets of relatives
+// overlap share a cluster. Each focal's relatives are visited once, so this
+// takes time proportional to the total size of those sets. Clusters are
+// sorted by size, then number of focals, largest first, then by first member.
+func RelativeClustersIndexed(p *IndexedPed, dist int, focals ...int) []RelativeCluster {
+	n := len(p.IDs)
+	set := newDisjointSet(n)
+	member := make([]bool, n)
+	isFocal := make([]bool, n)
+	depth := make([]int, n)
+	visited := make([]int, n) // the 1-based number of the last focal to visit each individual
+	var queue []int
+	for k, f := range focals {
+		isFocal[f] = true
+		visited[f] = k + 1
+		depth[f] = 0
+		queue = append(queue[:0], f)
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			member[i] = true
+			set.union(f, i)
+			if depth[i] == dist {
+				continue
 			}
-			for id, _ := range node.ChildIDs {
-				neighbors = append(neighbors, id)
+			visit := func(j int) {
+				if j != -1 && visited[j] != k+1 {
+					visited[j] = k + 1
+					depth[j] = depth[i] + 1
+					queue = append(queue, j)
+				}
 			}
-		}
-		for _, n := range neighbors {
-			if _, ok := found[n]; !ok {
-				found[n] = struct{}{}
-				ret[d] = append(ret[d], n)
+			visit(p.Father[i])
+			visit(p.Mother[i])
+			for _, j := range p.Children[i] {
+				visit(j)
 			}
 		}
 	}
-	return ret
-}
 
-// If a set of clusters share some individuals, merge them into superclusters
-func MergeClusters(clusters *[]map[string]struct{}, hits []int) []int {
-	newcl := map[string]struct{}{}
-	for _, hit := range hits {
-		for id, _ := range (*clusters)[hit] {
-			newcl[id] = struct{}{}
+	// IDs are sorted, so walking indices in order keeps members sorted
+	byRoot := map[int]int{}
+	var out []RelativeCluster
+	for i := 0; i < n; i++ {
+		if !member[i] {
+			continue
+		}
+		root := set.find(i)
+		c, ok := byRoot[root]
+		if !ok {
+			c = len(out)
+			byRoot[root] = c
+			out = append(out, RelativeCluster{})
+		}
+		out[c].Members = append(out[c].Members, p.IDs[i])
+		if isFocal[i] {
+			out[c].Focals = append(out[c].Focals, p.IDs[i])
 		}
 	}
-	slices.SortFunc(hits, func(i, j int) int {
-		return j - i
-	})
-	for _, hit := range hits {
-		slices.Delete(*clusters, hit, hit + 1)
+	for i := range out {
+		out[i].Size = len(out[i].Members)
+		out[i].NFocal = len(out[i].Focals)
 	}
-	*clusters = append(*clusters, newcl)
-	return []int{len(*clusters) - 1}
+	slices.SortStableFunc(out, func(a, b RelativeCluster) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.NFocal, a.NFocal); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Members[0], b.Members[0])
+	})
+	return out
 }
 
-// For a set of focalIDs, generate clusters of relatives that are within dist of them
-func RelativeClusters(tree map[string]Node, dist int, focalIDs ...string) []map[string]struct{} {
-	clusters := []map[string]struct{}{}
-	for _, u := range focalIDs {
-		rels := Relatives(tree, u, dist)
-		clhits := []int{}
-		for _, relset := range rels {
-			for _, rel := range relset {
-				for i, cl := range clusters {
-					if _, ok := cl[rel]; ok {
-						clhits = append(clhits, i)
-					}
-				}
-			}
+// Copy tree, adding a founder node for every parent that is named by an
+// individual but not in the tree itself. Without these, IndexedPed drops the
+// link to such a parent, so clusters would lose the parent and siblings who
+// share only that parent would not be related.
+func WithParentPlaceholders(tree map[string]Node) map[string]Node {
+	out := maps.Clone(tree)
+	add := func(child PedEntry, id string, sex int64) {
+		if IsOrphan(id) {
+			return
 		}
-		if len(clhits) > 1 {
-			clhits = MergeClusters(&clusters, clhits)
+		if _, ok := tree[id]; ok {
+			return
 		}
-		if len(clhits) < 1 {
-			clusters = append(clusters, map[string]struct{}{})
-			clhits = []int{len(clusters) - 1}
+		node, ok := out[id]
+		if !ok {
+			node = Node{
+				PedEntry: PedEntry{FamilyID: child.FamilyID, IndividualID: id, PaternalID: "0", MaternalID: "0", Sex: sex},
+				ChildIDs: map[string]struct{}{},
+			}
+			out[id] = node
 		}
+		node.ChildIDs[child.IndividualID] = struct{}{}
+	}
+	for _, node := range tree {
+		add(node.PedEntry, node.PaternalID, 1)
+		add(node.PedEntry, node.MaternalID, 2)
+	}
+	return out
+}
 
-		clusters[clhits[0]][u] = struct{}{}
-		for _,relset := range rels {
-			for _, rel := range relset {
-				clusters[clhits[0]][rel] = struct{}{}
-			}
+// For a set of focalIDs, generate clusters of relatives that are within dist
+// of them, as RelativeClustersIndexed does, including parents that are named
+// but not in the tree. Focal IDs missing from the tree get a cluster of their
+// own.
+func RelativeClusters(tree map[string]Node, dist int, focalIDs ...string) []map[string]struct{} {
+	p := NewIndexedPed(WithParentPlaceholders(tree))
+	var focals []int
+	var missing []string
+	for _, id := range focalIDs {
+		if i, ok := p.Index[id]; ok {
+			focals = append(focals, i)
+		} else {
+			missing = append(missing, id)
 		}
 	}
+	clusters := []map[string]struct{}{}
+	for _, c := range RelativeClustersIndexed(p, dist, focals...) {
+		cl := make(map[string]struct{}, len(c.Members))
+		for _, id := range c.Members {
+			cl[id] = struct{}{}
+		}
+		clusters = append(clusters, cl)
+	}
+	for _, id := range missing {
+		clusters = append(clusters, map[string]struct{}{id: struct{}{}})
+	}
 	return clusters
 }
 
@@ -103,9 +207,14 @@ func RelativeClusters(tree map[string]Node, dist int, focalIDs ...string) []map[
 
 // Filter a sequence of Entries based on posterior probability
 func FilterPed(it iter.Seq[Entry], minimum float64) iter.Seq[Entry] {
+	return FilterPedBy(it, minimum, PosteriorScore)
+}
+
+// Filter a sequence of Entries to those with a finite score of at least minimum
+func FilterPedBy(it iter.Seq[Entry], minimum float64, score ScoreFunc) iter.Seq[Entry] {
 	return func(y func(Entry) bool) {
 		for ent := range it {
-			if ent.Posterior >= minimum {
+			if v := score(ent); FiniteScore(v) && v >= minimum {
 				if !y(ent) {
 					return
 				}
@@ -161,6 +270,136 @@ type ClusterFlags struct {
 	Header bool
 	Steps int
 	Thresh float64
+	Score string
+	BgPathsPath string
+	BgHeader bool
+	Null string
+	Quantile float64
+	Workers int
+	Perms int
+	Seed int
+	BgNull bool
+	Format string
+}
+
+// The score threshold for significance, and where it came from: the -t flag,
+// or a quantile of a null distribution read from NBackground background files,
+// leaving out NSkipped entries whose score was not finite
+type ClusterThreshold struct {
+	Threshold   float64
+	Source      string
+	Null        PermlikeNull `json:",omitempty"`
+	Quantile    float64      `json:",omitempty"`
+	NBackground int          `json:",omitempty"`
+	NSkipped    int          `json:",omitempty"`
+}
+
+// Derive a significance threshold from background WARP files: the q quantile
+// of the null distribution chosen by null, such as the largest score of each
+// background pedigree. The threshold is compared with individual scores, so
+// the mean null is rejected, as in Permlike.
+func CalibrateClusterThreshold(bgPaths []string, null PermlikeNull, q float64, opt BackgroundOptions, nworkers int) (ClusterThreshold, error) {
+	h := csvh.Handle1[ClusterThreshold]("CalibrateClusterThreshold: %w")
+	if len(bgPaths) < 1 {
+		return h(fmt.Errorf("no background paths"))
+	}
+	if !null.PerIndividual() {
+		return h(fmt.Errorf("null %q does not test individual scores; use max or pooled", string(null)))
+	}
+	nullDist, nskipped, e := PermlikeNullDist(bgPaths, null, opt, nworkers)
+	if e != nil {
+		return h(e)
+	}
+	return ClusterThreshold{
+		Threshold:   Quantile(nullDist, q),
+		Source:      "background",
+		Null:        null,
+		Quantile:    q,
+		NBackground: len(bgPaths),
+		NSkipped:    nskipped,
+	}, nil
+}
+
+// Describe the threshold in one line
+func (t ClusterThreshold) String() string {
+	if t.Source != "background" {
+		return fmt.Sprintf("threshold %v from %v", t.Threshold, t.Source)
+	}
+	return fmt.Sprintf("threshold %v from the %v quantile of the %v of %v background pedigrees", t.Threshold, t.Quantile, t.Null, t.NBackground)
+}
+
+// Expand any replicate manifests among paths into their WARP output paths
+func ExpandManifests(paths ...string) ([]string, error) {
+	var out []string
+	for _, path := range paths {
+		isManifest, e := IsManifestPath(path)
+		if e != nil {
+			return nil, e
+		}
+		if !isManifest {
+			out = append(out, path)
+			continue
+		}
+		m, e := ReadManifestPath(path)
+		if e != nil {
+			return nil, e
+		}
+		outputs, e := m.OutputPaths()
+		if e != nil {
+			return nil, e
+		}
+		out = append(out, outputs...)
+	}
+	return out, nil
+}
+
+// The clusters of one WARP output file, with the threshold and steps used to
+// make them, and the clustering test if one was run
+type ClusterReport struct {
+	Path      string
+	Threshold ClusterThreshold
+	Steps     int
+	NHits     int
+	Clusters  []RelativeCluster
+	Test      *ClusterTest `json:",omitempty"`
+}
+
+// Cluster the significant individuals in one WARP output file
+func ClusterPath(path string, header bool, steps int, thresh ClusterThreshold, score ScoreFunc) (ClusterReport, ClusterInput, error) {
+	in, e := ReadClusterInput(path, header, thresh.Threshold, score)
+	if e != nil {
+		return ClusterReport{}, ClusterInput{}, fmt.Errorf("ClusterPath: %w", e)
+	}
+	r := ClusterReport{
+		Path:      path,
+		Threshold: thresh,
+		Steps:     steps,
+		NHits:     len(in.Hits),
+		Clusters:  RelativeClustersIndexed(in.Ped, steps, in.Hits...),
+	}
+	if r.Clusters == nil {
+		r.Clusters = []RelativeCluster{}
+	}
+	return r, in, nil
+}
+
+// Write the members of each cluster, one cluster per line
+func WriteClusters(w io.Writer, clusters []RelativeCluster) error {
+	for _, c := range clusters {
+		if _, e := fmt.Fprintln(w, strings.Join(c.Members, " ")); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Cluster the significant individuals in one WARP output file and write one cluster per line
+func WriteClustersPath(w io.Writer, path string, header bool, steps int, thresh float64, score ScoreFunc) error {
+	r, _, e := ClusterPath(path, header, steps, ClusterThreshold{Threshold: thresh, Source: "-t"}, score)
+	if e != nil {
+		return e
+	}
+	return WriteClusters(w, r.Clusters)
 }
 
 // Run all clustering code on the command line
@@ -169,22 +408,76 @@ func FullCluster() {
 	flag.BoolVar(&f.Header, "h", false, "Parse a WARP output file with a header")
 	flag.IntVar(&f.Steps, "s", 1, "Number of steps allowed between family members")
 	flag.Float64Var(&f.Thresh, "t", 1.0, "Likelihood threshold for an individual counting as significant")
+	flag.StringVar(&f.Score, "score", "posterior", "WARP column or derived score to compare to the threshold: "+ScoreFuncNames())
+	flag.StringVar(&f.BgPathsPath, "b", "", "Path to list of paths containing warp output for background data, or a pedshufsex replicate manifest, to derive the threshold from unless -t is given")
+	flag.BoolVar(&f.BgHeader, "bh", false, "Background data has a header line")
+	flag.StringVar(&f.Null, "null", "max", "Null distribution for -b: max of each background pedigree, or pooled individuals")
+	flag.Float64Var(&f.Quantile, "q", 0.95, "Null quantile to use as the threshold with -b")
+	flag.IntVar(&f.Workers, "w", runtime.NumCPU(), "Number of background files to read, or -perm replicates to run, in parallel")
+	flag.IntVar(&f.Perms, "perm", 0, "Test whether hits cluster more than this many random sets of as many individuals (0 to skip); each replicate searches the whole pedigree from up to 100 hits")
+	flag.IntVar(&f.Seed, "seed", 0, "Random seed for -perm")
+	flag.BoolVar(&f.BgNull, "bnull", false, "Test whether hits cluster more than as many top-scoring individuals of each -b background pedigree")
+	flag.StringVar(&f.Format, "f", "text", "Output format: text, or json with one report per WARP file")
 	flag.Parse()
 	args := flag.Args()
 	if len(args) < 1 {
-		fmt.Printf("usage: %v [-h] warpout.ped", os.Args[0])
+		fmt.Printf("usage: %v [-h] warpout.ped|manifest.json...", os.Args[0])
 		return
 	}
-	warp, errp := iterh.BreakWithError(ParsePedPath(args[0], f.Header))
-	tree := BuildPedTree(slices.Collect(ToPedEntries(warp))...)
-	if *errp != nil {
-		log.Fatal(*errp)
+	paths, e := ExpandManifests(args...)
+	if e != nil {
+		log.Fatal(e)
+	}
+	score, e := ParseScoreFunc(f.Score)
+	if e != nil {
+		log.Fatal(e)
 	}
-	goods := slices.Collect(ToIndividualID(FilterPed(warp, f.Thresh)))
-	if *errp != nil {
-		log.Fatal(*errp)
+
+	if f.Format != "text" && f.Format != "json" {
+		log.Fatal(fmt.Errorf("unknown format %q", f.Format))
+	}
+	if f.BgNull && f.BgPathsPath == "" {
+		log.Fatal(fmt.Errorf("-bnull needs -b"))
+	}
+	if f.BgNull && f.Perms > 0 {
+		log.Fatal(fmt.Errorf("give only one of -perm and -bnull"))
+	}
+	threshSet := false
+	flag.Visit(func(fl *flag.Flag) {
+		threshSet = threshSet || fl.Name == "t"
+	})
+	var bgPaths []string
+	if f.BgPathsPath != "" {
+		bgPaths, e = BackgroundPaths(f.BgPathsPath)
+		Must(e)
+	}
+
+	thresh := ClusterThreshold{Threshold: f.Thresh, Source: "-t"}
+	if f.BgPathsPath != "" && !threshSet {
+		null := PermlikeNull(f.Null)
+		Must(null.Validate())
+		if f.Quantile < 0 || f.Quantile >= 1 {
+			log.Fatal(fmt.Errorf("-q %v is not in [0, 1)", f.Quantile))
+		}
+		thresh, e = CalibrateClusterThreshold(bgPaths, null, f.Quantile, BackgroundOptions{Header: f.BgHeader, Score: score}, f.Workers)
+		Must(e)
+	}
+
+	reports := make([]ClusterReport, len(paths))
+	inputs := make([]ClusterInput, len(paths))
+	for i, path := range paths {
+		reports[i], inputs[i], e = ClusterPath(path, f.Header, f.Steps, thresh, score)
+		Must(e)
+	}
+	var bgStats map[int][]ClusterStats
+	if f.BgNull {
+		var nhits []int
+		for _, r := range reports {
+			nhits = append(nhits, r.NHits)
+		}
+		bgStats, e = BackgroundClusterStats(bgPaths, f.BgHeader, score, f.Steps, nhits, f.Workers)
+		Must(e)
 	}
-	clusters := RelativeClusters(tree, f.Steps, goods...)
 
 	w := bufio.NewWriter(os.Stdout)
 	defer func() {
@@ -193,18 +486,34 @@ func FullCluster() {
 			log.Fatal(e)
 		}
 	}()
-	for _, c := range clusters {
-		i := 0
-		for id, _ := range c {
-			if i < 1 {
-				fmt.Fprintf(w, "%v", id)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if f.Format == "text" {
+		fmt.Fprintf(w, "# %v\n", thresh)
+	}
+	for i, path := range paths {
+		r := reports[i]
+		if f.Perms > 0 || f.BgNull {
+			var test ClusterTest
+			if f.BgNull {
+				test, e = NewClusterTest(HitClusterStats(inputs[i].Ped, inputs[i].Hits, f.Steps), "background", bgStats[r.NHits])
 			} else {
-				fmt.Fprintf(w, " %v", id)
+				test, e = RelabelClusterTest(inputs[i], f.Steps, uint64(f.Seed), f.Perms, f.Workers)
 			}
-			i++
+			Must(e)
+			r.Test = &test
+		}
+
+		if f.Format == "json" {
+			Must(enc.Encode(r))
+			continue
+		}
+		if len(paths) > 1 {
+			fmt.Fprintf(w, "# %v\n", path)
 		}
-		if len(c) > 0 {
-			fmt.Fprintf(w, "\n")
+		Must(WriteClusters(w, r.Clusters))
+		if r.Test != nil {
+			fmt.Fprintf(w, "# %v\n", *r.Test)
 		}
 	}
 }
@@ -3,6 +3,9 @@ package tdt
 import (
 	"testing"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 
@@ -29,3 +32,203 @@ func TestRelativeClusters(t *testing.T) {
 	fmt.Println("tree:", tree)
 	fmt.Println("goods:", goods)
 }
+
+func TestCalibrateClusterThreshold(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	// background i has largest posterior 0.5 + i/10
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("bg%v.txt", i))
+		bg := strings.ReplaceAll(exampleWarp, "0.3\t0.1\t", fmt.Sprintf("0.3\t%v\t", 0.5+float64(i)/10))
+		if e := os.WriteFile(path, []byte(bg), 0644); e != nil {
+			t.Fatal(e)
+		}
+		paths = append(paths, path)
+	}
+	th, e := CalibrateClusterThreshold(paths, NullMax, 0.8, BackgroundOptions{}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if th.Threshold != 0.9 || th.Source != "background" || th.NBackground != 5 {
+		t.Errorf("threshold %+v", th)
+	}
+	if _, e := CalibrateClusterThreshold(nil, NullMax, 0.8, BackgroundOptions{}, 2); e == nil {
+		t.Errorf("no error without backgrounds")
+	}
+	if _, e := CalibrateClusterThreshold(paths, NullMean, 0.8, BackgroundOptions{}, 2); e == nil {
+		t.Errorf("no error with the mean null")
+	}
+}
+
+func TestHitClusterStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warp.txt")
+	if e := os.WriteFile(path, []byte(exampleWarp), 0644); e != nil {
+		t.Fatal(e)
+	}
+	in, e := ReadClusterInput(path, false, 0.45, PosteriorScore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	p, hits := in.Ped, in.Hits
+	// the placeholder parents 1002 and 1003 are not listed, so never relabeled as hits
+	if len(in.Listed) != 7 || len(p.IDs) != 9 {
+		t.Errorf("%v listed of %v individuals", len(in.Listed), len(p.IDs))
+	}
+	// 1 and 2 are the parents of 3; 1001's parents are not listed
+	s := HitClusterStats(p, hits, 1)
+	// closeness: 1/2 for 1 and 2, 1 each with 3, and 0 for the three pairs with 1001
+	if s.NHits != 4 || s.NClusters != 2 || s.NUnconnected != 3 || s.MeanDistance != 4.0/3 || s.MeanCloseness != 2.5/6 {
+		t.Errorf("stats %+v", s)
+	}
+	if s := HitClusterStats(p, hits, 0); s.NClusters != 4 {
+		t.Errorf("stats with 0 steps %+v", s)
+	}
+
+	t1, e := RelabelClusterTest(in, 1, 7, 50, 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t3, e := RelabelClusterTest(in, 1, 7, 50, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if t1 != t3 {
+		t.Errorf("result depends on workers: %+v, %+v", t1, t3)
+	}
+	if t1.NReplicates != 50 || t1.ClustersP <= 0 || t1.ClustersP > 1 || t1.Observed != s {
+		t.Errorf("test %+v", t1)
+	}
+}
+
+func TestCountHitClusters(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	for steps := 0; steps < 4; steps++ {
+		// every subset of the pedigree, as a bit mask
+		for mask := 0; mask < 1<<len(p.IDs); mask += 37 {
+			var hits []int
+			for i := range p.IDs {
+				if mask&(1<<i) != 0 {
+					hits = append(hits, i)
+				}
+			}
+			if got, want := countHitClusters(p, hits, steps), len(RelativeClustersIndexed(p, steps, hits...)); got != want {
+				t.Errorf("steps %v, hits %v: %v clusters, RelativeClustersIndexed made %v", steps, hits, got, want)
+			}
+		}
+	}
+}
+
+func TestHitClusterStatsSampled(t *testing.T) {
+	// two unrelated chains of 150 fathers and sons
+	var ps []PedEntry
+	for c := 0; c < 2; c++ {
+		for i := 0; i < 150; i++ {
+			father := "0"
+			if i > 0 {
+				father = fmt.Sprintf("c%v_%v", c, i-1)
+			}
+			ps = append(ps, PedEntry{FamilyID: "1", IndividualID: fmt.Sprintf("c%v_%v", c, i), PaternalID: father, MaternalID: "0", Sex: 1})
+		}
+	}
+	p := NewIndexedPed(BuildPedTree(ps...))
+	hits := make([]int, len(p.IDs))
+	for i := range hits {
+		hits[i] = i
+	}
+	s := HitClusterStats(p, hits, 1)
+	if s.NHits != 300 || s.NClusters != 2 || s.NSources != MaxDistanceSources || s.NUnconnected != 150*150 {
+		t.Errorf("stats %+v", s)
+	}
+	closeness, npairs := 0.0, 0
+	for i := range hits {
+		dist := PedDistances(p, i)
+		for j := i + 1; j < len(hits); j++ {
+			npairs++
+			if dist[j] > 0 {
+				closeness += 1 / float64(dist[j])
+			}
+		}
+	}
+	if want := closeness / float64(npairs); math.Abs(s.MeanCloseness-want) > 0.1*want {
+		t.Errorf("estimated closeness %v, exact %v", s.MeanCloseness, want)
+	}
+}
+
+func TestBackgroundClusterStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bg.txt")
+	bg := strings.Replace(exampleWarp, "0.3\t0.4\t", "0.3\t0.9\t", 1)
+	bg = strings.Replace(bg, "0.3\t0.1\t", "0.3\t0.8\t", 1)
+	if e := os.WriteFile(path, []byte(bg), 0644); e != nil {
+		t.Fatal(e)
+	}
+	p, ranked, e := ReadRankedClusterInput(path, false, PosteriorScore)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var ids []string
+	for _, i := range ranked {
+		ids = append(ids, p.IDs[i])
+	}
+	if want := []string{"4", "6", "1", "2", "3", "1001", "5"}; !slices.Equal(ids, want) {
+		t.Errorf("ranked %v, want %v", ids, want)
+	}
+
+	stats, e := BackgroundClusterStats([]string{path}, false, PosteriorScore, 1, []int{2, 4, 100}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// 4 and 6 are parent and child; 1 and 2 are parents of 4
+	want := map[int]ClusterStats{
+		2:   {NHits: 2, NClusters: 1, MeanDistance: 1, MeanCloseness: 1, NSources: 2},
+		4:   {NHits: 4, NClusters: 1, MeanDistance: 1.5, MeanCloseness: 0.75, NSources: 4},
+		100: HitClusterStats(p, ranked, 1),
+	}
+	for n, w := range want {
+		if len(stats[n]) != 1 || stats[n][0] != w {
+			t.Errorf("%v hits: stats %+v, want %+v", n, stats[n], w)
+		}
+	}
+}
+
+func TestRelativeClustersIndexed(t *testing.T) {
+	warp, err := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarp), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := BuildPedTree(slices.Collect(ToPedEntries(slices.Values(warp)))...)
+	p := NewIndexedPed(WithParentPlaceholders(tree))
+	var hits []int
+	for _, id := range []string{"1001", "3", "1", "2"} {
+		hits = append(hits, p.Index[id])
+	}
+	clusters := RelativeClustersIndexed(p, 1, hits...)
+	want := []RelativeCluster{
+		{Size: 4, NFocal: 3, Focals: []string{"1", "2", "3"}, Members: []string{"1", "2", "3", "4"}},
+		{Size: 3, NFocal: 1, Focals: []string{"1001"}, Members: []string{"1001", "1002", "1003"}},
+	}
+	if fmt.Sprint(clusters) != fmt.Sprint(want) {
+		t.Errorf("clusters %v, want %v", clusters, want)
+	}
+	if s := HitClusterStats(p, hits, 1); s.NClusters != len(clusters) {
+		t.Errorf("HitClusterStats found %v clusters, not %v", s.NClusters, len(clusters))
+	}
+
+	maps := RelativeClusters(tree, 1, "1001", "3", "1", "2", "nobody")
+	if len(maps) != 3 || len(maps[0]) != 4 || len(maps[1]) != 3 || len(maps[2]) != 1 {
+		t.Errorf("cluster maps %v", maps)
+	}
+
+	// half-siblings whose only shared parent is not listed are still relatives
+	sibs := BuildPedTree(
+		PedEntry{FamilyID: "2", IndividualID: "2001", PaternalID: "2000", MaternalID: "0", Sex: 1},
+		PedEntry{FamilyID: "2", IndividualID: "2002", PaternalID: "2000", MaternalID: "0", Sex: 2},
+	)
+	sp := NewIndexedPed(WithParentPlaceholders(sibs))
+	clusters = RelativeClustersIndexed(sp, 1, sp.Index["2001"], sp.Index["2002"])
+	if len(clusters) != 1 || !slices.Equal(clusters[0].Members, []string{"2000", "2001", "2002"}) {
+		t.Errorf("sibling clusters %v", clusters)
+	}
+	if len(sibs) != 2 {
+		t.Errorf("WithParentPlaceholders changed its input: %v", sibs)
+	}
+}
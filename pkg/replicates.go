@@ -0,0 +1,80 @@
+package tdt
+
+import (
+	"flag"
+	mrand "math/rand"
+	"runtime"
+	"sync"
+
+	"golang.org/x/exp/rand"
+)
+
+// Adapts a golang.org/x/exp/rand source for use with math/rand
+type mathRandSource struct {
+	src rand.Source
+}
+
+func (s mathRandSource) Int63() int64 {
+	return int64(s.src.Uint64() >> 1)
+}
+
+func (s mathRandSource) Seed(seed int64) {
+	s.src.Seed(uint64(seed))
+}
+
+// Wrap a replicate's source in a math/rand generator, for code such as ShufPedSex that uses math/rand
+func MathRand(src rand.Source) *mrand.Rand {
+	return mrand.New(mathRandSource{src})
+}
+
+// Register the -w flag, which sets workers, for commands that run
+// replicates with RunReplicates
+func ReplicateWorkersFlag(workers *int) {
+	flag.IntVar(workers, "w", runtime.NumCPU(), "Number of replicates to run in parallel; results do not depend on it")
+}
+
+// Derive the seed of replicate i from the master seed with the SplitMix64
+// mixer. Each replicate gets its own random stream, so a replicate's result
+// does not depend on which worker runs it or in what order.
+func ReplicateSeed(master uint64, i int) uint64 {
+	z := master + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Run nreps replicates on nworkers goroutines. Each worker gets its own
+// accumulator from newAcc, and replicate i is run as rep(acc, i, src) with a
+// source seeded by ReplicateSeed(seed, i). The worker accumulators are then
+// combined with merge, in worker order. As long as merge is order-independent
+// (for example, summing counts), the result is the same for any number of
+// workers.
+func RunReplicates[A any](seed uint64, nreps, nworkers int, newAcc func() A, rep func(acc A, i int, src rand.Source), merge func(dst, src A)) A {
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	jobs := make(chan int, nworkers)
+	accs := make([]A, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		accs[w] = newAcc()
+		wg.Add(1)
+		go func(acc A) {
+			defer wg.Done()
+			for i := range jobs {
+				rep(acc, i, rand.NewSource(ReplicateSeed(seed, i)))
+			}
+		}(accs[w])
+	}
+	for i := 0; i < nreps; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	out := accs[0]
+	for _, acc := range accs[1:] {
+		merge(out, acc)
+	}
+	return out
+}
@@ -0,0 +1,256 @@
+package tdt
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jgbaldwinbrown/csvh"
+)
+
+// One run of an external scorer: score the pedigree at Ped and write Output
+type RunJob struct {
+	Replicate int
+	Ped       string
+	Output    string
+}
+
+// What happened to one job. Skipped jobs already had their output. ExitCode
+// is -1 if the command could not be started.
+type RunResult struct {
+	Replicate int
+	Ped       string
+	Output    string
+	Log       string
+	Skipped   bool
+	ExitCode  int
+	Seconds   float64
+	Error     string `json:",omitempty"`
+}
+
+// The jobs for every replicate in a manifest. Every replicate must have an Output path.
+func ManifestJobs(m ReplicateManifest) ([]RunJob, error) {
+	jobs := make([]RunJob, 0, len(m.Replicates))
+	for _, rep := range m.Replicates {
+		if rep.Output == "" {
+			return nil, fmt.Errorf("ManifestJobs: replicate %v (%v) has no output path", rep.Replicate, rep.Path)
+		}
+		jobs = append(jobs, RunJob{Replicate: rep.Replicate, Ped: rep.Path, Output: rep.Output})
+	}
+	return jobs, nil
+}
+
+// Quote s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Strip a .ped or .ped.gz extension
+func pedBase(path string) string {
+	for _, ext := range []string{".ped.gz", ".ped"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// Fill in a command template. {ped} is the pedigree path, {out} the path to
+// write, {base} the pedigree path without its .ped or .ped.gz extension, and
+// {rep} the replicate number. Paths are shell-quoted.
+func ExpandCommand(tmpl string, job RunJob, out string) string {
+	return strings.NewReplacer(
+		"{ped}", shellQuote(job.Ped),
+		"{out}", shellQuote(out),
+		"{base}", shellQuote(pedBase(job.Ped)),
+		"{rep}", strconv.Itoa(job.Replicate),
+	).Replace(tmpl)
+}
+
+// Run one job with sh. The command writes to a temporary path, either
+// through {out} or, if the template has no {out}, through its standard
+// output, and the result is renamed to job.Output only if the command
+// succeeds, so an existing output is always complete. Standard error goes to
+// Output + ".log".
+func RunJobCmd(tmpl string, job RunJob) (res RunResult) {
+	res = RunResult{Replicate: job.Replicate, Ped: job.Ped, Output: job.Output, Log: job.Output + ".log"}
+	start := time.Now()
+	defer func() { res.Seconds = time.Since(start).Seconds() }()
+	fail := func(e error) RunResult {
+		res.Error = e.Error()
+		return res
+	}
+
+	tmp := job.Output + ".tmp"
+	logw, e := os.Create(res.Log)
+	if e != nil {
+		res.ExitCode = -1
+		return fail(e)
+	}
+	defer func() {
+		if e := logw.Close(); e != nil && res.Error == "" {
+			res.Error = e.Error()
+		}
+	}()
+
+	cmd := exec.Command("sh", "-c", ExpandCommand(tmpl, job, tmp))
+	cmd.Stderr = logw
+	var outw *os.File
+	if !strings.Contains(tmpl, "{out}") {
+		if outw, e = os.Create(tmp); e != nil {
+			res.ExitCode = -1
+			return fail(e)
+		}
+		cmd.Stdout = outw
+	}
+
+	e = cmd.Run()
+	if outw != nil {
+		if ce := outw.Close(); e == nil {
+			e = ce
+		}
+	}
+	if e != nil {
+		var exitErr *exec.ExitError
+		if errors.As(e, &exitErr) {
+			res.ExitCode = exitErr.ExitCode()
+		} else {
+			res.ExitCode = -1
+		}
+		os.Remove(tmp)
+		return fail(e)
+	}
+	if e := os.Rename(tmp, job.Output); e != nil {
+		return fail(e)
+	}
+	return res
+}
+
+// Run every job whose output does not exist yet, on nworkers goroutines.
+// Results are returned in the order of jobs.
+func RunJobs(tmpl string, jobs []RunJob, nworkers int) []RunResult {
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	out := make([]RunResult, len(jobs))
+	queue := make(chan int, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				job := jobs[i]
+				if _, e := os.Stat(job.Output); e == nil {
+					out[i] = RunResult{Replicate: job.Replicate, Ped: job.Ped, Output: job.Output, Skipped: true}
+					continue
+				}
+				out[i] = RunJobCmd(tmpl, job)
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return out
+}
+
+// Count results by outcome
+func CountRunResults(rs []RunResult) (nran, nskipped, nfailed int) {
+	for _, r := range rs {
+		switch {
+		case r.Skipped:
+			nskipped++
+		case r.Error != "":
+			nfailed++
+		default:
+			nran++
+		}
+	}
+	return nran, nskipped, nfailed
+}
+
+// Write one line per job
+func WriteRunResults(w io.Writer, rs []RunResult) error {
+	cw := csvh.CsvOut(w)
+	if e := cw.Write([]string{"Replicate", "Ped", "Output", "Log", "Skipped", "ExitCode", "Seconds", "Error"}); e != nil {
+		return e
+	}
+	for _, r := range rs {
+		if e := cw.Write([]string{
+			strconv.Itoa(r.Replicate),
+			r.Ped,
+			r.Output,
+			r.Log,
+			strconv.FormatBool(r.Skipped),
+			strconv.Itoa(r.ExitCode),
+			fmt.Sprint(r.Seconds),
+			r.Error,
+		}); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Flags for FullRunScorer
+type RunScorerFlags struct {
+	ManifestPath string
+	Command      string
+	Workers      int
+	OutPath      string
+	Format       string
+}
+
+// Run on the command line
+func FullRunScorer() {
+	var f RunScorerFlags
+	flag.StringVar(&f.ManifestPath, "m", "", "Replicate manifest from pedshufsex -wo or warpinput, giving each replicate's pedigree and output path")
+	flag.StringVar(&f.Command, "c", "", "Command to run on each replicate with sh, with {ped}, {out}, {base} and {rep} filled in; without {out}, standard output is the output")
+	flag.IntVar(&f.Workers, "w", runtime.NumCPU(), "Number of commands to run at once")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write the result of each job (default stdout)")
+	flag.StringVar(&f.Format, "f", "tsv", "Output format: tsv or json")
+	flag.Parse()
+	if f.ManifestPath == "" {
+		log.Fatal(fmt.Errorf("missing -m"))
+	}
+	if f.Command == "" {
+		log.Fatal(fmt.Errorf("missing -c"))
+	}
+	if f.Format != "tsv" && f.Format != "json" {
+		log.Fatal(fmt.Errorf("unknown format %q", f.Format))
+	}
+
+	m, e := ReadManifestPath(f.ManifestPath)
+	Must(e)
+	jobs, e := ManifestJobs(m)
+	Must(e)
+	results := RunJobs(f.Command, jobs, f.Workers)
+
+	Must(WriteOutput(f.OutPath, func(w io.Writer) error {
+		if f.Format == "json" {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			return enc.Encode(results)
+		}
+		return WriteRunResults(w, results)
+	}))
+	nran, nskipped, nfailed := CountRunResults(results)
+	log.Printf("ran %v, skipped %v already complete, %v failed", nran, nskipped, nfailed)
+	if nfailed > 0 {
+		os.Exit(1)
+	}
+}
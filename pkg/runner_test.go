@@ -0,0 +1,92 @@
+package tdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Stands in for WARP: writes one WARP output line per .ped line, and fails
+// while the file named by its third argument exists
+const fakeScorer = `#!/bin/sh
+if [ -e "$3" ]; then
+	echo "failing on purpose" >&2
+	exit 3
+fi
+awk -v OFS='\t' '{ print $1, $2, $3, $4, $5, $6, "", 0.1, $5 * 0.2 + NR * 0.01, 0.5, 0.5 }' "$1" > "$2"
+`
+
+func TestRunJobs(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "scorer.sh")
+	if e := os.WriteFile(script, []byte(fakeScorer), 0755); e != nil {
+		t.Fatal(e)
+	}
+	// replicate 2 fails while the flag file fail2 exists
+	failFlag := filepath.Join(dir, "fail")
+	if e := os.WriteFile(failFlag+"2", nil, 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	ps := SortedUniqPed(scanExamplePed()...)
+	m := ReplicateManifest{Seed: 1, Mode: string(ShufAll)}
+	for i := 0; i < 4; i++ {
+		rep := ManifestReplicate{
+			Replicate: i,
+			Seed:      ReplicateSeed(m.Seed, i),
+			Path:      filepath.Join(dir, "shuf_"+string(rune('0'+i))+".ped"),
+			Output:    filepath.Join(dir, "shuf_"+string(rune('0'+i))+".warp.txt"),
+		}
+		if e := WritePedPath(rep.Path, ShufReplicate(ps, rep.Seed, ShufAll, false)); e != nil {
+			t.Fatal(e)
+		}
+		m.Replicates = append(m.Replicates, rep)
+	}
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if e := WriteManifestPath(manifestPath, m); e != nil {
+		t.Fatal(e)
+	}
+	jobs, e := ManifestJobs(m)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	tmpl := script + " {ped} {out} '" + failFlag + "'{rep}"
+	rs := RunJobs(tmpl, jobs, 2)
+	if nran, nskipped, nfailed := CountRunResults(rs); nran != 3 || nskipped != 0 || nfailed != 1 {
+		t.Fatalf("first run: ran %v, skipped %v, failed %v: %+v", nran, nskipped, nfailed, rs)
+	}
+	if r := rs[2]; r.ExitCode != 3 || r.Error == "" {
+		t.Errorf("failed job %+v", r)
+	}
+	if _, e := os.Stat(jobs[2].Output); e == nil {
+		t.Errorf("failed job left output %v", jobs[2].Output)
+	}
+	if b, e := os.ReadFile(rs[2].Log); e != nil || string(b) != "failing on purpose\n" {
+		t.Errorf("log %q, %v", b, e)
+	}
+
+	// resume: only the failed job runs again
+	if e := os.Remove(failFlag + "2"); e != nil {
+		t.Fatal(e)
+	}
+	rs = RunJobs(tmpl, jobs, 2)
+	if nran, nskipped, nfailed := CountRunResults(rs); nran != 1 || nskipped != 3 || nfailed != 0 {
+		t.Fatalf("second run: ran %v, skipped %v, failed %v: %+v", nran, nskipped, nfailed, rs)
+	}
+
+	// the outputs feed outlier through the manifest
+	bgPaths, e := BackgroundPaths(manifestPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bgs, e := SummarizeBackgrounds(bgPaths, BackgroundOptions{}, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, bg := range bgs {
+		if bg.N != len(ps) {
+			t.Errorf("output %v has %v entries, not %v", i, bg.N, len(ps))
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package tdt
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/jgbaldwinbrown/csvh"
+)
+
+// A pedigree with every individual replaced by an integer index, so that
+// lineages can be walked many times without map lookups. Father and Mother are
+// -1 when the parent is not in the pedigree.
+type IndexedPed struct {
+	IDs      []string
+	Index    map[string]int
+	Sex      []int64
+	Father   []int
+	Mother   []int
+	Children [][]int
+}
+
+// Build an IndexedPed from a tree. Individuals are indexed in sorted ID order so that the indices are reproducible.
+func NewIndexedPed(tree map[string]Node) *IndexedPed {
+	ids := slices.Sorted(maps.Keys(tree))
+	p := &IndexedPed{
+		IDs:      ids,
+		Index:    make(map[string]int, len(ids)),
+		Sex:      make([]int64, len(ids)),
+		Father:   make([]int, len(ids)),
+		Mother:   make([]int, len(ids)),
+		Children: make([][]int, len(ids)),
+	}
+	for i, id := range ids {
+		p.Index[id] = i
+	}
+	for i, id := range ids {
+		node := tree[id]
+		p.Sex[i] = node.Sex
+		p.Father[i] = p.parentIndex(node.PaternalID)
+		p.Mother[i] = p.parentIndex(node.MaternalID)
+	}
+	for i := range ids {
+		if f := p.Father[i]; f != -1 {
+			p.Children[f] = append(p.Children[f], i)
+		}
+		if m := p.Mother[i]; m != -1 && m != p.Father[i] {
+			p.Children[m] = append(p.Children[m], i)
+		}
+	}
+	return p
+}
+
+func (p *IndexedPed) parentIndex(id string) int {
+	if IsOrphan(id) {
+		return -1
+	}
+	if i, ok := p.Index[id]; ok {
+		return i
+	}
+	return -1
+}
+
+// Copy p, replacing its sexes with sex. The pedigree structure is shared with p.
+func (p *IndexedPed) WithSex(sex []int64) *IndexedPed {
+	q := *p
+	q.Sex = sex
+	return &q
+}
+
+// Check if i has at least one parent in the pedigree
+func (p *IndexedPed) IsOffspring(i int) bool {
+	return p.Father[i] != -1 || p.Mother[i] != -1
+}
+
+// Count the male and female offspring in the whole pedigree
+func (p *IndexedPed) OffspringTotals() Family {
+	var tot Family
+	for i := range p.IDs {
+		if !p.IsOffspring(i) {
+			continue
+		}
+		switch p.Sex[i] {
+		case 1:
+			tot.MaleF1++
+		case 2:
+			tot.FemaleF1++
+		}
+	}
+	return tot
+}
+
+// A chromosome whose line of descent can be followed through the pedigree
+type LineageKind int
+
+const (
+	LineageY LineageKind = iota
+	LineageX
+	LineageAuto
+)
+
+func (k LineageKind) String() string {
+	switch k {
+	case LineageY:
+		return "Y"
+	case LineageX:
+		return "X"
+	case LineageAuto:
+		return "Auto"
+	}
+	return fmt.Sprintf("LineageKind(%d)", int(k))
+}
+
+// Parse a comma-separated list of lineage kinds, such as "Y,X,Auto"
+func ParseLineageKinds(s string) ([]LineageKind, error) {
+	var kinds []LineageKind
+	for _, field := range strings.Split(s, ",") {
+		switch strings.TrimSpace(field) {
+		case "Y":
+			kinds = append(kinds, LineageY)
+		case "X":
+			kinds = append(kinds, LineageX)
+		case "Auto":
+			kinds = append(kinds, LineageAuto)
+		default:
+			return nil, fmt.Errorf("ParseLineageKinds: unknown lineage %q", field)
+		}
+	}
+	return kinds, nil
+}
+
+// Check if child receives the lineage chromosome from parent, following the same rules as HasY, HasX and HasAuto
+func (p *IndexedPed) Inherits(kind LineageKind, child, parent int) bool {
+	switch kind {
+	case LineageY:
+		return p.Sex[child] == 1 && p.Father[child] == parent
+	case LineageX:
+		return (p.Sex[child] == 1 && p.Mother[child] == parent) || p.Sex[child] == 2
+	case LineageAuto:
+		return true
+	}
+	return false
+}
+
+// Check if focal can found a lineage of this kind. Only males found Y lineages.
+func (p *IndexedPed) CanFound(kind LineageKind, focal int) bool {
+	if len(p.Children[focal]) < 1 {
+		return false
+	}
+	if kind == LineageY {
+		return p.Sex[focal] == 1
+	}
+	return true
+}
+
+// Reusable buffers for walking lineages
+type lineageWalker struct {
+	carrier []int
+	counted []int
+	stack   []int
+	stamp   int
+}
+
+func newLineageWalker(n int) *lineageWalker {
+	return &lineageWalker{carrier: make([]int, n), counted: make([]int, n)}
+}
+
+// Count the distinct offspring of every carrier of focal's chromosome. Unlike
+// BuildFamiliesY and friends, an offspring with two carrier parents is only
+// counted once, so that inside and outside counts partition the pedigree.
+func (w *lineageWalker) totals(p *IndexedPed, kind LineageKind, focal int) Family {
+	var fam Family
+	w.stamp++
+	w.carrier[focal] = w.stamp
+	w.stack = append(w.stack[:0], focal)
+	for len(w.stack) > 0 {
+		c := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+		for _, ch := range p.Children[c] {
+			if w.counted[ch] != w.stamp {
+				w.counted[ch] = w.stamp
+				switch p.Sex[ch] {
+				case 1:
+					fam.MaleF1++
+				case 2:
+					fam.FemaleF1++
+				}
+			}
+			if w.carrier[ch] != w.stamp && p.Inherits(kind, ch, c) {
+				w.carrier[ch] = w.stamp
+				w.stack = append(w.stack, ch)
+			}
+		}
+	}
+	return fam
+}
+
+// Count the distinct male and female offspring in the lineage of focal
+func (p *IndexedPed) LineageTotals(kind LineageKind, focal int) Family {
+	return newLineageWalker(len(p.IDs)).totals(p, kind, focal)
+}
+
+// Collect a family for every carrier of focal's chromosome, as BuildFamiliesY and friends do
+func (p *IndexedPed) LineageFamilies(kind LineageKind, focal int) []Family {
+	carrier := map[int]struct{}{focal: struct{}{}}
+	stack := []int{focal}
+	var fams []Family
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		var fam Family
+		for _, ch := range p.Children[c] {
+			switch p.Sex[ch] {
+			case 1:
+				fam.MaleF1++
+			case 2:
+				fam.FemaleF1++
+			}
+			if _, ok := carrier[ch]; !ok && p.Inherits(kind, ch, c) {
+				carrier[ch] = struct{}{}
+				stack = append(stack, ch)
+			}
+		}
+		fams = append(fams, fam)
+	}
+	return fams
+}
+
+// Run the TDT test on the lineage of every individual that can found one, as tdtall does for Y lineages
+func TDTScan(p *IndexedPed, kind LineageKind, x ExpectedRatio) []TDTResult {
+	var out []TDTResult
+	for i, id := range p.IDs {
+		if !p.CanFound(kind, i) {
+			continue
+		}
+		res := TDTTestRatio(x.For(p, kind, id), p.LineageFamilies(kind, i)...)
+		res.Name = id
+		res.Orphan = p.Father[i] == -1
+		out = append(out, res)
+	}
+	return out
+}
+
+func xlogy(x, y float64) float64 {
+	if x == 0 {
+		return 0
+	}
+	return x * math.Log(y)
+}
+
+// Calculate Kulldorff's Bernoulli log likelihood ratio for m males and f
+// females inside a lineage, out of totM males and totF females overall. The
+// ratio is two-sided, so it is large for an excess of either sex.
+func BernoulliLLR(m, f, totM, totF float64) float64 {
+	n := m + f
+	total := totM + totF
+	if n <= 0 || n >= total {
+		return 0
+	}
+	om := totM - m
+	of := totF - f
+	llr := xlogy(m, m/n) + xlogy(f, f/n) +
+		xlogy(om, om/(total-n)) + xlogy(of, of/(total-n)) -
+		xlogy(totM, totM/total) - xlogy(totF, totF/total)
+	return math.Max(llr, 0)
+}
+
+// One lineage tested by the scan statistic
+type ScanResult struct {
+	Focal          string
+	Lineage        string
+	Males          float64
+	Females        float64
+	OutsideMales   float64
+	OutsideFemales float64
+	Excess         string
+	LLR            float64
+}
+
+// Calculate the scan statistic for every lineage of every kind in p, in index order
+func ScanLineages(p *IndexedPed, kinds ...LineageKind) []ScanResult {
+	tot := p.OffspringTotals()
+	w := newLineageWalker(len(p.IDs))
+	var out []ScanResult
+	for i, id := range p.IDs {
+		for _, kind := range kinds {
+			if !p.CanFound(kind, i) {
+				continue
+			}
+			fam := w.totals(p, kind, i)
+			r := ScanResult{
+				Focal:          id,
+				Lineage:        kind.String(),
+				Males:          fam.MaleF1,
+				Females:        fam.FemaleF1,
+				OutsideMales:   tot.MaleF1 - fam.MaleF1,
+				OutsideFemales: tot.FemaleF1 - fam.FemaleF1,
+				Excess:         "female",
+				LLR:            BernoulliLLR(fam.MaleF1, fam.FemaleF1, tot.MaleF1, tot.FemaleF1),
+			}
+			if r.Males*(r.OutsideMales+r.OutsideFemales) > r.OutsideMales*(r.Males+r.Females) {
+				r.Excess = "male"
+			}
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Find the lineage with the largest scan statistic. Ties go to the first lineage in index order.
+func MaxScan(rs []ScanResult) (ScanResult, bool) {
+	if len(rs) < 1 {
+		return ScanResult{}, false
+	}
+	best := rs[0]
+	for _, r := range rs[1:] {
+		if r.LLR > best.LLR {
+			best = r
+		}
+	}
+	return best, true
+}
+
+// Calculate only the largest scan statistic in p, without keeping every lineage
+func MaxLLR(p *IndexedPed, kinds ...LineageKind) float64 {
+	tot := p.OffspringTotals()
+	w := newLineageWalker(len(p.IDs))
+	best := 0.0
+	for i := range p.IDs {
+		for _, kind := range kinds {
+			if !p.CanFound(kind, i) {
+				continue
+			}
+			fam := w.totals(p, kind, i)
+			best = math.Max(best, BernoulliLLR(fam.MaleF1, fam.FemaleF1, tot.MaleF1, tot.FemaleF1))
+		}
+	}
+	return best
+}
+
+// Generate one null replicate of a pedigree, changing only the sexes of offspring
+type PedNull func(p *IndexedPed) *IndexedPed
+
+// Shuffle sexes among offspring that are not themselves parents. Parents keep
+// the sex implied by their parental role, so every lineage keeps its shape and
+// the total number of males and females is unchanged.
+func PermuteLeafSex(r *rand.Rand) PedNull {
+	return func(p *IndexedPed) *IndexedPed {
+		sex := slices.Clone(p.Sex)
+		var leaves []int
+		for i := range p.IDs {
+			if p.IsOffspring(i) && len(p.Children[i]) < 1 {
+				leaves = append(leaves, i)
+			}
+		}
+		r.Shuffle(len(leaves), func(i, j int) {
+			sex[leaves[i]], sex[leaves[j]] = sex[leaves[j]], sex[leaves[i]]
+		})
+		return p.WithSex(sex)
+	}
+}
+
+// The most extreme lineage in a pedigree and its significance against null replicates
+type ScanTestResult struct {
+	Best         ScanResult
+	Replicates   int
+	NullAsLarge  int
+	P            float64
+	NullMeanLLR  float64
+	NullLLR95    float64
+	TotalMales   float64
+	TotalFemales float64
+}
+
+// Run the scan statistic on p, then compare its maximum to the maximum of each of reps null replicates
+func ScanTest(p *IndexedPed, null PedNull, reps int, kinds ...LineageKind) (ScanTestResult, error) {
+	var res ScanTestResult
+	best, ok := MaxScan(ScanLineages(p, kinds...))
+	if !ok {
+		return res, fmt.Errorf("ScanTest: no lineages to scan")
+	}
+	tot := p.OffspringTotals()
+	res.Best = best
+	res.Replicates = reps
+	res.TotalMales = tot.MaleF1
+	res.TotalFemales = tot.FemaleF1
+
+	nullMaxes := make([]float64, 0, reps)
+	for i := 0; i < reps; i++ {
+		m := MaxLLR(null(p), kinds...)
+		if m >= best.LLR {
+			res.NullAsLarge++
+		}
+		nullMaxes = append(nullMaxes, m)
+	}
+	res.P = float64(res.NullAsLarge+1) / float64(reps+1)
+	if reps > 0 {
+		sum := 0.0
+		for _, m := range nullMaxes {
+			sum += m
+		}
+		res.NullMeanLLR = sum / float64(reps)
+		res.NullLLR95 = Quantile(nullMaxes, 0.95)
+	}
+	return res, nil
+}
+
+// Flags for FullScan
+type ScanFlags struct {
+	PedPath  string
+	OutPath  string
+	Lineages string
+	Reps     int
+	Seed     int
+	All      bool
+}
+
+// Run the pedigree scan statistic on the command line
+func FullScan() {
+	var f ScanFlags
+	flag.StringVar(&f.PedPath, "i", "", "path to input .ped file")
+	flag.StringVar(&f.OutPath, "o", "", "path to write output")
+	flag.StringVar(&f.Lineages, "l", "Y,X,Auto", "comma-separated lineages to scan")
+	flag.IntVar(&f.Reps, "r", 999, "Monte Carlo replicates")
+	flag.IntVar(&f.Seed, "s", 0, "random seed")
+	flag.BoolVar(&f.All, "a", false, "also write the scan statistic for every lineage")
+	flag.Parse()
+	if f.PedPath == "" {
+		log.Fatal(fmt.Errorf("missing -i"))
+	}
+	if f.OutPath == "" {
+		log.Fatal(fmt.Errorf("missing -o"))
+	}
+	kinds, e := ParseLineageKinds(f.Lineages)
+	Must(e)
+
+	r, e := csvh.OpenMaybeGz(f.PedPath)
+	Must(e)
+	defer r.Close()
+	peds, e := ParsePedSafe(r)
+	Must(e)
+	p := NewIndexedPed(BuildPedTree(peds...))
+
+	ww, e := csvh.CreateMaybeGz(f.OutPath)
+	Must(e)
+	defer func() {
+		Must(ww.Close())
+	}()
+	w := bufio.NewWriter(ww)
+	defer func() {
+		Must(w.Flush())
+	}()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+
+	rng := rand.New(rand.NewSource(int64(f.Seed)))
+	res, e := ScanTest(p, PermuteLeafSex(rng), f.Reps, kinds...)
+	Must(e)
+	Must(enc.Encode(res))
+
+	if f.All {
+		for _, lr := range ScanLineages(p, kinds...) {
+			Must(enc.Encode(lr))
+		}
+	}
+}
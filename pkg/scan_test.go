@@ -0,0 +1,131 @@
+package tdt
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func scanExamplePed() []PedEntry {
+	return []PedEntry{
+		PedEntry{"1", "1", "0", "0", 1, 1},
+		PedEntry{"1", "2", "0", "0", 2, 1},
+		PedEntry{"1", "3", "0", "0", 1, 1},
+		PedEntry{"1", "4", "0", "0", 2, 1},
+		PedEntry{"1", "5", "1", "2", 1, 1},
+		PedEntry{"1", "6", "1", "2", 1, 1},
+		PedEntry{"1", "7", "1", "2", 1, 1},
+		PedEntry{"1", "8", "1", "2", 1, 1},
+		PedEntry{"1", "9", "3", "4", 2, 1},
+		PedEntry{"1", "10", "3", "4", 1, 1},
+		PedEntry{"1", "11", "3", "4", 2, 1},
+		PedEntry{"1", "12", "3", "4", 2, 1},
+	}
+}
+
+func TestScanLineages(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	best, ok := MaxScan(ScanLineages(p, LineageY))
+	if !ok {
+		t.Fatal("no lineages")
+	}
+	if best.Focal != "1" || best.Excess != "male" {
+		t.Errorf("best %#v; expected focal 1 with excess males", best)
+	}
+	if best.Males != 4 || best.Females != 0 || best.OutsideMales != 1 || best.OutsideFemales != 3 {
+		t.Errorf("best counts %#v", best)
+	}
+	expect := 4*math.Log(1) + 1*math.Log(0.25) + 3*math.Log(0.75) - 5*math.Log(5.0/8.0) - 3*math.Log(3.0/8.0)
+	if math.Abs(best.LLR-expect) > 1e-12 {
+		t.Errorf("LLR %v != expect %v", best.LLR, expect)
+	}
+}
+
+func TestScanLineagesXAuto(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	// 1 and 2 have sons 5-8; 3 and 4 have one son and three daughters. The
+	// pedigree has two generations, so X and Auto lineages are the same.
+	llr := 4*math.Log(1) + 1*math.Log(0.25) + 3*math.Log(0.75) - 5*math.Log(5.0/8.0) - 3*math.Log(3.0/8.0)
+	want := map[string]ScanResult{
+		"1": {Males: 4, Females: 0, OutsideMales: 1, OutsideFemales: 3, Excess: "male"},
+		"2": {Males: 4, Females: 0, OutsideMales: 1, OutsideFemales: 3, Excess: "male"},
+		"3": {Males: 1, Females: 3, OutsideMales: 4, OutsideFemales: 0, Excess: "female"},
+		"4": {Males: 1, Females: 3, OutsideMales: 4, OutsideFemales: 0, Excess: "female"},
+	}
+	for _, kind := range []LineageKind{LineageX, LineageAuto} {
+		rs := ScanLineages(p, kind)
+		if len(rs) != len(want) {
+			t.Errorf("%v: %v lineages, want %v", kind, len(rs), len(want))
+		}
+		for _, r := range rs {
+			w, ok := want[r.Focal]
+			if !ok {
+				t.Errorf("%v: unexpected lineage of %v", kind, r.Focal)
+				continue
+			}
+			w.Focal, w.Lineage, w.LLR = r.Focal, kind.String(), r.LLR
+			if r != w || math.Abs(r.LLR-llr) > 1e-12 {
+				t.Errorf("%v: lineage %#v, want %#v with LLR %v", kind, r, w, llr)
+			}
+		}
+	}
+}
+
+func TestScanTest(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	kinds := []LineageKind{LineageY, LineageX, LineageAuto}
+	res, e := ScanTest(p, PermuteLeafSex(rand.New(rand.NewSource(0))), 4000, kinds...)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if res.TotalMales != 5 || res.TotalFemales != 3 {
+		t.Errorf("totals %v %v", res.TotalMales, res.TotalFemales)
+	}
+
+	// the exact permutation P: place the 3 daughters among the 8 leaves in
+	// every way and count the placements with a maximum as large as observed
+	var leaves []int
+	for i := range p.IDs {
+		if p.IsOffspring(i) && len(p.Children[i]) < 1 {
+			leaves = append(leaves, i)
+		}
+	}
+	nAsLarge, n := 0, 0
+	for a := 0; a < len(leaves); a++ {
+		for b := a + 1; b < len(leaves); b++ {
+			for c := b + 1; c < len(leaves); c++ {
+				sex := slices.Clone(p.Sex)
+				for _, l := range leaves {
+					sex[l] = 1
+				}
+				sex[leaves[a]], sex[leaves[b]], sex[leaves[c]] = 2, 2, 2
+				if MaxLLR(p.WithSex(sex), kinds...) >= res.Best.LLR {
+					nAsLarge++
+				}
+				n++
+			}
+		}
+	}
+	// only the 8 placements with all of one family's children the same sex are as extreme
+	exact := float64(nAsLarge) / float64(n)
+	if nAsLarge != 8 || n != 56 {
+		t.Errorf("%v of %v placements as large", nAsLarge, n)
+	}
+	if math.Abs(res.P-exact) > 0.03 {
+		t.Errorf("P %v, exact permutation P %v", res.P, exact)
+	}
+
+	// with every leaf male there is nothing to permute, so every replicate is as large
+	sons := p.WithSex(slices.Clone(p.Sex))
+	for _, l := range leaves {
+		sons.Sex[l] = 1
+	}
+	res, e = ScanTest(sons, PermuteLeafSex(rand.New(rand.NewSource(0))), 50, kinds...)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if res.P != 1 || res.NullAsLarge != 50 {
+		t.Errorf("P %v with %v replicates as large, want 1", res.P, res.NullAsLarge)
+	}
+}
@@ -0,0 +1,86 @@
+package tdt
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Extract the value to rank WARP entries by. Larger values are more significant.
+type ScoreFunc func(Entry) float64
+
+func PosteriorScore(ent Entry) float64 {
+	return ent.Posterior
+}
+
+func PriorScore(ent Entry) float64 {
+	return ent.Prior
+}
+
+func PhenoRiskScore(ent Entry) float64 {
+	return ent.PhenoRisk
+}
+
+// GenoRisk parsed as a number, or NaN if WARP did not write a number
+func GenoRiskScore(ent Entry) float64 {
+	f, e := strconv.ParseFloat(strings.TrimSpace(ent.GenoRisk), 64)
+	if e != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// How many times more likely the posterior makes an individual a carrier than the prior did
+func LiftScore(ent Entry) float64 {
+	return ent.Posterior / ent.Prior
+}
+
+// Whether a score can be ranked. GenoRiskScore is NaN when WARP did not write
+// a number, and LiftScore is NaN or infinite when the prior is 0; such
+// scores are skipped rather than ranked.
+func FiniteScore(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// The entries of ents with a finite score, and the number skipped
+func FiniteEntries(ents []Entry, score ScoreFunc) ([]Entry, int) {
+	out := make([]Entry, 0, len(ents))
+	for _, ent := range ents {
+		if FiniteScore(score(ent)) {
+			out = append(out, ent)
+		}
+	}
+	return out, len(ents) - len(out)
+}
+
+// Score functions that can be chosen by name on the command line
+var ScoreFuncs = map[string]ScoreFunc{
+	"prior":     PriorScore,
+	"posterior": PosteriorScore,
+	"phenorisk": PhenoRiskScore,
+	"genorisk":  GenoRiskScore,
+	"lift":      LiftScore,
+}
+
+// The names accepted by ParseScoreFunc, for flag help
+func ScoreFuncNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(ScoreFuncs)), ", ")
+}
+
+// Look up a score function by name
+func ParseScoreFunc(name string) (ScoreFunc, error) {
+	f, ok := ScoreFuncs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("ParseScoreFunc: unknown score %q; choose from %v", name, ScoreFuncNames())
+	}
+	return f, nil
+}
+
+// An entry with its score
+type ScoredEntry struct {
+	Entry
+	Score float64
+}
@@ -0,0 +1,80 @@
+package tdt
+
+import (
+	"flag"
+	"fmt"
+	"math"
+)
+
+// How to choose the male fraction that a lineage's offspring are tested
+// against. If FromPed is set, the expected fraction is the male fraction of
+// all offspring outside the focal lineage; otherwise it is MaleProb.
+type ExpectedRatio struct {
+	MaleProb float64
+	FromPed  bool
+}
+
+// Check that the fixed male fraction can be tested against
+func (x ExpectedRatio) Validate() error {
+	if x.MaleProb <= 0 || x.MaleProb >= 1 {
+		return fmt.Errorf("expected male fraction %v not between 0 and 1", x.MaleProb)
+	}
+	return nil
+}
+
+// Register the -m and -mp flags, which set x
+func RegisterExpectedRatioFlags(x *ExpectedRatio) {
+	flag.Float64Var(&x.MaleProb, "m", 0.5, "Expected male fraction of offspring")
+	flag.BoolVar(&x.FromPed, "mp", false, "Estimate the expected male fraction from the pedigree, excluding the focal lineage")
+}
+
+// Get the male fraction for the pedigree as a whole, ignoring lineages
+func (x ExpectedRatio) Overall(p *IndexedPed) float64 {
+	if !x.FromPed {
+		return x.MaleProb
+	}
+	if frac := p.MaleFraction(); testableFraction(frac) {
+		return frac
+	}
+	return x.MaleProb
+}
+
+// Whether a male fraction can be tested against: a fraction of 0 or 1 makes
+// the chi-squared statistic infinite, and NaN (no offspring) makes it NaN
+func testableFraction(frac float64) bool {
+	return !math.IsNaN(frac) && frac > 0 && frac < 1
+}
+
+// Fraction of males among all offspring in p, or NaN if p has no offspring
+func (p *IndexedPed) MaleFraction() float64 {
+	tot := p.OffspringTotals()
+	return tot.MaleF1 / (tot.MaleF1 + tot.FemaleF1)
+}
+
+// Fraction of males among the offspring in p that are not in focal's
+// lineage, or NaN if every offspring is in the lineage
+func (p *IndexedPed) OutsideMaleFraction(kind LineageKind, focal int) float64 {
+	tot := p.OffspringTotals()
+	in := p.LineageTotals(kind, focal)
+	outM := tot.MaleF1 - in.MaleF1
+	outF := tot.FemaleF1 - in.FemaleF1
+	return outM / (outM + outF)
+}
+
+// Get the expected male fraction for focal's lineage. Falls back to MaleProb
+// when focal is not in p, or when the fraction outside the lineage cannot be
+// tested against: there are no offspring outside the lineage, or they are all
+// one sex.
+func (x ExpectedRatio) For(p *IndexedPed, kind LineageKind, focalID string) float64 {
+	if !x.FromPed || p == nil {
+		return x.MaleProb
+	}
+	focal, ok := p.Index[focalID]
+	if !ok {
+		return x.MaleProb
+	}
+	if frac := p.OutsideMaleFraction(kind, focal); testableFraction(frac) {
+		return frac
+	}
+	return x.MaleProb
+}
@@ -0,0 +1,63 @@
+package tdt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChiSqTrioRatio(t *testing.T) {
+	for _, bc := range [][2]float64{{10, 10}, {30, 10}, {3, 17}} {
+		if got, want := ChiSqTrioRatio(bc[0], bc[1], 0.5), ChiSqTrio(bc[0], bc[1]); math.Abs(got-want) > 1e-12 {
+			t.Errorf("%v: ratio 0.5 gives %v, ChiSqTrio %v", bc, got, want)
+		}
+	}
+	// 30 males of 40 is exactly the expected 3/4
+	if got := ChiSqTrioRatio(30, 10, 0.75); got != 0 {
+		t.Errorf("chisq %v != 0", got)
+	}
+	// 20 males of 40 against 1/4: (20 - 10)^2 / (40 * 1/4 * 3/4) = 40/3
+	r := TDTTestRatio(0.25, Family{12, 8}, Family{8, 12})
+	if math.Abs(r.Chisq-40.0/3) > 1e-12 {
+		t.Errorf("chisq %v != 40/3", r.Chisq)
+	}
+	if want := math.Erfc(math.Sqrt(r.Chisq / 2)); math.Abs(r.P-want) > 1e-12 {
+		t.Errorf("P %v != %v", r.P, want)
+	}
+	if r.ExpectedMaleProp != 0.25 || r.MaleProportion != 0.5 || r.Nfamilies != 2 || r.MeanChildrenPerFam != 20 {
+		t.Errorf("result %+v", r)
+	}
+	if r0 := TDTTest(Family{12, 8}, Family{8, 12}); r0.Chisq != 0 || r0.P != 1 {
+		t.Errorf("TDTTest %+v", r0)
+	}
+}
+
+func TestExpectedRatio(t *testing.T) {
+	p := NewIndexedPed(BuildPedTree(scanExamplePed()...))
+	x := ExpectedRatio{MaleProb: 0.5, FromPed: true}
+	// the offspring outside 1's lineage are 9..12: one male, three females
+	if got := x.For(p, LineageY, "1"); got != 0.25 {
+		t.Errorf("For(1) %v != 0.25", got)
+	}
+	// the offspring outside 3's lineage are 5..8, all male, so MaleProb is used
+	if got := x.For(p, LineageY, "3"); got != 0.5 {
+		t.Errorf("For(3) %v != 0.5", got)
+	}
+	if got := x.Overall(p); got != 5.0/8 {
+		t.Errorf("Overall %v != 5/8", got)
+	}
+	if got := x.For(p, LineageY, "nobody"); got != 0.5 {
+		t.Errorf("For(nobody) %v != 0.5", got)
+	}
+
+	// with no offspring, every fraction is NaN and MaleProb is used instead
+	founders := NewIndexedPed(BuildPedTree(scanExamplePed()[:4]...))
+	if !math.IsNaN(founders.MaleFraction()) {
+		t.Errorf("MaleFraction %v is not NaN", founders.MaleFraction())
+	}
+	if got := x.Overall(founders); got != 0.5 {
+		t.Errorf("Overall without offspring %v", got)
+	}
+	if got := x.For(founders, LineageY, "1"); got != 0.5 {
+		t.Errorf("For without offspring %v", got)
+	}
+}
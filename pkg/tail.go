@@ -0,0 +1,327 @@
+package tdt
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/montanaflynn/stats"
+	"gonum.org/v1/gonum/optimize"
+)
+
+// A distribution fitted to the upper tail of a null distribution
+type TailModel string
+
+const (
+	TailNone   TailModel = "none"
+	TailGumbel TailModel = "gumbel" // extreme value distribution with Xi == 0, for per-replicate maxima
+	TailGEV    TailModel = "gev"    // generalized extreme value distribution, for per-replicate maxima
+	TailGPD    TailModel = "gpd"    // generalized Pareto distribution of the excesses over a threshold, for pooled values
+)
+
+func (m TailModel) Validate() error {
+	switch m {
+	case TailNone, TailGumbel, TailGEV, TailGPD:
+		return nil
+	}
+	return fmt.Errorf("unknown tail model %q; choose none, gumbel, gev or gpd", string(m))
+}
+
+// How well a fitted distribution matches the values it was fitted to. KS is
+// the Kolmogorov-Smirnov distance and KSP its asymptotic p-value; AD is the
+// Anderson-Darling statistic. Because the parameters were estimated from the
+// same values, KSP is conservative (too large).
+type GoodnessOfFit struct {
+	N      int
+	LogLik float64
+	KS     float64
+	KSP    float64
+	AD     float64
+}
+
+// A fitted tail distribution. For GEV and Gumbel fits, Mu, Sigma and Xi are
+// the location, scale and shape. For GPD fits, Mu is the threshold, Sigma and
+// Xi describe the excesses over it, and ExceedFrac is the fraction of values
+// above the threshold. Converged is false if the optimizer stopped early,
+// such as at its iteration limit; the parameters are then its best so far,
+// and the tail p-values should not be trusted.
+type TailFit struct {
+	Model      TailModel
+	Mu         float64
+	Sigma      float64
+	Xi         float64
+	ExceedFrac float64 `json:",omitempty"`
+	Converged  bool
+	Fit        GoodnessOfFit
+}
+
+// Treat shapes this close to zero as exactly zero, to avoid cancellation
+const xiEps = 1e-8
+
+// Upper tail probability of the generalized extreme value distribution
+func gevSurvival(x, mu, sigma, xi float64) float64 {
+	z := (x - mu) / sigma
+	if math.Abs(xi) < xiEps {
+		return -math.Expm1(-math.Exp(-z))
+	}
+	u := 1 + xi*z
+	if u <= 0 {
+		if xi > 0 {
+			return 1
+		}
+		return 0
+	}
+	return -math.Expm1(-math.Pow(u, -1/xi))
+}
+
+func gevLogPdf(x, mu, sigma, xi float64) float64 {
+	z := (x - mu) / sigma
+	if math.Abs(xi) < xiEps {
+		return -math.Log(sigma) - z - math.Exp(-z)
+	}
+	u := 1 + xi*z
+	if u <= 0 {
+		return math.Inf(-1)
+	}
+	return -math.Log(sigma) - (1+1/xi)*math.Log(u) - math.Pow(u, -1/xi)
+}
+
+// Upper tail probability of the generalized Pareto distribution at excess y >= 0
+func gpdSurvival(y, sigma, xi float64) float64 {
+	if y <= 0 {
+		return 1
+	}
+	if math.Abs(xi) < xiEps {
+		return math.Exp(-y / sigma)
+	}
+	u := 1 + xi*y/sigma
+	if u <= 0 {
+		return 0
+	}
+	return math.Pow(u, -1/xi)
+}
+
+func gpdLogPdf(y, sigma, xi float64) float64 {
+	if y < 0 {
+		return math.Inf(-1)
+	}
+	if math.Abs(xi) < xiEps {
+		return -math.Log(sigma) - y/sigma
+	}
+	u := 1 + xi*y/sigma
+	if u <= 0 {
+		return math.Inf(-1)
+	}
+	return -math.Log(sigma) - (1+1/xi)*math.Log(u)
+}
+
+// The probability of a value above x under the fitted distribution. For GPD
+// fits, ok is false when x is not above the threshold, where the tail model
+// says nothing and the empirical p-value should be used instead.
+func (t TailFit) P(x float64) (p float64, ok bool) {
+	switch t.Model {
+	case TailGumbel, TailGEV:
+		return gevSurvival(x, t.Mu, t.Sigma, t.Xi), true
+	case TailGPD:
+		if x <= t.Mu {
+			return 0, false
+		}
+		return t.ExceedFrac * gpdSurvival(x-t.Mu, t.Sigma, t.Xi), true
+	}
+	return 0, false
+}
+
+// Major iterations allowed when fitting a tail model
+const tailIterations = 5000
+
+// Minimize the negative log likelihood with Nelder-Mead, for at most maxIter
+// major iterations. Parameters outside the support get a large finite penalty
+// so the simplex can move away from them. If the optimizer stops early, its
+// best parameters so far are returned with converged false.
+func maxLogLik(loglik func(params []float64) float64, init []float64, maxIter int) (params []float64, ll float64, converged bool, err error) {
+	const penalty = 1e100
+	prob := optimize.Problem{
+		Func: func(params []float64) float64 {
+			ll := loglik(params)
+			if math.IsNaN(ll) || math.IsInf(ll, 0) {
+				return penalty
+			}
+			return -ll
+		},
+	}
+	res, e := optimize.Minimize(prob, init, &optimize.Settings{MajorIterations: maxIter}, &optimize.NelderMead{})
+	if res == nil {
+		return nil, 0, false, e
+	}
+	if res.F >= penalty {
+		return nil, 0, false, fmt.Errorf("no parameters fit the data")
+	}
+	return res.X, -res.F, e == nil && !res.Status.Early(), nil
+}
+
+// Method of moments estimates of the Gumbel location and scale
+func gumbelMoments(xs []float64) (mu, sigma float64, err error) {
+	mean, e := stats.Mean(xs)
+	if e != nil {
+		return 0, 0, e
+	}
+	sd, e := stats.StandardDeviation(xs)
+	if e != nil {
+		return 0, 0, e
+	}
+	if sd <= 0 {
+		return 0, 0, fmt.Errorf("all values are equal")
+	}
+	sigma = sd * math.Sqrt(6) / math.Pi
+	return mean - 0.5772156649015329*sigma, sigma, nil
+}
+
+// Fit a Gumbel (xi == 0) or generalized extreme value distribution to
+// per-replicate maxima by maximum likelihood
+func FitGEV(xs []float64, gumbel bool) (TailFit, error) {
+	h := func(e error) (TailFit, error) {
+		return TailFit{}, fmt.Errorf("FitGEV: %w", e)
+	}
+	if len(xs) < 3 {
+		return h(fmt.Errorf("need at least 3 values, have %v", len(xs)))
+	}
+	mu0, sigma0, e := gumbelMoments(xs)
+	if e != nil {
+		return h(e)
+	}
+
+	gumbelLL := func(p []float64) float64 {
+		ll := 0.0
+		for _, x := range xs {
+			ll += gevLogPdf(x, p[0], math.Exp(p[1]), 0)
+		}
+		return ll
+	}
+	p, ll, converged, e := maxLogLik(gumbelLL, []float64{mu0, math.Log(sigma0)}, tailIterations)
+	if e != nil {
+		return h(e)
+	}
+	t := TailFit{Model: TailGumbel, Mu: p[0], Sigma: math.Exp(p[1]), Converged: converged}
+
+	if !gumbel {
+		gevLL := func(p []float64) float64 {
+			ll := 0.0
+			for _, x := range xs {
+				ll += gevLogPdf(x, p[0], math.Exp(p[1]), p[2])
+			}
+			return ll
+		}
+		p, ll, converged, e = maxLogLik(gevLL, []float64{t.Mu, math.Log(t.Sigma), 0.1}, tailIterations)
+		if e != nil {
+			return h(e)
+		}
+		t = TailFit{Model: TailGEV, Mu: p[0], Sigma: math.Exp(p[1]), Xi: p[2], Converged: converged}
+	}
+
+	t.Fit = goodnessOfFit(xs, ll, func(x float64) float64 {
+		return 1 - gevSurvival(x, t.Mu, t.Sigma, t.Xi)
+	})
+	return t, nil
+}
+
+// Fit a generalized Pareto distribution to the excesses of xs over its
+// quantile thresholdQ, by maximum likelihood
+func FitGPD(xs []float64, thresholdQ float64) (TailFit, error) {
+	h := func(e error) (TailFit, error) {
+		return TailFit{}, fmt.Errorf("FitGPD: %w", e)
+	}
+	u := Quantile(xs, thresholdQ)
+	var ys []float64
+	for _, x := range xs {
+		if x > u {
+			ys = append(ys, x-u)
+		}
+	}
+	if len(ys) < 3 {
+		return h(fmt.Errorf("need at least 3 values above the threshold %v, have %v", u, len(ys)))
+	}
+	mean, e := stats.Mean(ys)
+	if e != nil {
+		return h(e)
+	}
+
+	ll := func(p []float64) float64 {
+		out := 0.0
+		for _, y := range ys {
+			out += gpdLogPdf(y, math.Exp(p[0]), p[1])
+		}
+		return out
+	}
+	p, maxll, converged, e := maxLogLik(ll, []float64{math.Log(mean), 0}, tailIterations)
+	if e != nil {
+		return h(e)
+	}
+	t := TailFit{
+		Model:      TailGPD,
+		Mu:         u,
+		Sigma:      math.Exp(p[0]),
+		Xi:         p[1],
+		ExceedFrac: float64(len(ys)) / float64(len(xs)),
+		Converged:  converged,
+	}
+	t.Fit = goodnessOfFit(ys, maxll, func(y float64) float64 {
+		return 1 - gpdSurvival(y, t.Sigma, t.Xi)
+	})
+	return t, nil
+}
+
+// Fit the chosen tail model. ThresholdQ is only used by GPD fits.
+func FitTail(model TailModel, xs []float64, thresholdQ float64) (TailFit, error) {
+	switch model {
+	case TailGumbel:
+		return FitGEV(xs, true)
+	case TailGEV:
+		return FitGEV(xs, false)
+	case TailGPD:
+		return FitGPD(xs, thresholdQ)
+	}
+	return TailFit{}, fmt.Errorf("FitTail: cannot fit model %q", string(model))
+}
+
+// Asymptotic p-value of the Kolmogorov-Smirnov distance d between n values and a distribution
+func ksPValue(d float64, n int) float64 {
+	sn := math.Sqrt(float64(n))
+	lambda := (sn + 0.12 + 0.11/sn) * d
+	if lambda < 1e-3 {
+		return 1
+	}
+	sum := 0.0
+	sign := 1.0
+	for j := 1; j <= 100; j++ {
+		term := sign * math.Exp(-2*float64(j*j)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return min(max(2*sum, 0), 1)
+}
+
+func goodnessOfFit(xs []float64, loglik float64, cdf func(float64) float64) GoodnessOfFit {
+	sorted := slices.Sorted(slices.Values(xs))
+	n := len(sorted)
+	g := GoodnessOfFit{N: n, LogLik: loglik}
+	fs := make([]float64, n)
+	for i, x := range sorted {
+		f := cdf(x)
+		fs[i] = f
+		g.KS = max(g.KS, f-float64(i)/float64(n), float64(i+1)/float64(n)-f)
+	}
+	g.KSP = ksPValue(g.KS, n)
+
+	const tiny = 1e-300
+	ad := 0.0
+	for i := range fs {
+		lo := max(fs[i], tiny)
+		hi := max(1-fs[n-1-i], tiny)
+		ad += float64(2*i+1) * (math.Log(lo) + math.Log(hi))
+	}
+	g.AD = -float64(n) - ad/float64(n)
+	return g
+}
@@ -0,0 +1,64 @@
+package tdt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFitTail(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const mu, sigma = 3.0, 0.5
+	xs := make([]float64, 2000)
+	for i := range xs {
+		xs[i] = mu - sigma*math.Log(-math.Log(r.Float64()))
+	}
+
+	for _, model := range []TailModel{TailGumbel, TailGEV} {
+		fit, e := FitTail(model, xs, 0)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if math.Abs(fit.Mu-mu) > 0.1 || math.Abs(fit.Sigma-sigma) > 0.1 || math.Abs(fit.Xi) > 0.1 {
+			t.Errorf("%v: bad fit %+v", model, fit)
+		}
+		if !fit.Converged {
+			t.Errorf("%v: fit did not converge", model)
+		}
+		if fit.Fit.KSP < 0.01 {
+			t.Errorf("%v: KS test rejects the true model: %+v", model, fit.Fit)
+		}
+		p, ok := fit.P(mu + 5*sigma)
+		if !ok || math.Abs(p-gevSurvival(mu+5*sigma, mu, sigma, 0)) > 0.005 {
+			t.Errorf("%v: tail p %v", model, p)
+		}
+	}
+
+	fit, e := FitTail(TailGPD, xs, 0.9)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if math.Abs(fit.ExceedFrac-0.1) > 0.01 {
+		t.Errorf("GPD exceedance fraction %v", fit.ExceedFrac)
+	}
+	if _, ok := fit.P(fit.Mu - 1); ok {
+		t.Errorf("GPD p-value below the threshold")
+	}
+	if p, ok := fit.P(fit.Mu + 1e-9); !ok || math.Abs(p-fit.ExceedFrac) > 1e-6 {
+		t.Errorf("GPD p-value at the threshold %v", p)
+	}
+}
+
+func TestMaxLogLikConverged(t *testing.T) {
+	ll := func(p []float64) float64 {
+		return -(p[0]-3)*(p[0]-3) - (p[1]+1)*(p[1]+1)
+	}
+	p, _, converged, e := maxLogLik(ll, []float64{0, 0}, tailIterations)
+	if e != nil || !converged || math.Abs(p[0]-3) > 1e-3 || math.Abs(p[1]+1) > 1e-3 {
+		t.Errorf("params %v, converged %v, error %v", p, converged, e)
+	}
+	p, _, converged, e = maxLogLik(ll, []float64{0, 0}, 2)
+	if e != nil || converged || p == nil {
+		t.Errorf("after 2 iterations: params %v, converged %v, error %v", p, converged, e)
+	}
+}
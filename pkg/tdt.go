@@ -21,6 +21,13 @@ func ChiSqTrio(b, c float64) float64 {
 	return chisq
 }
 
+// Same as ChiSqTrio, but testing against an expected male fraction of pmale instead of 0.5
+func ChiSqTrioRatio(b, c, pmale float64) float64 {
+	n := b + c
+	dev := b - n*pmale
+	return (dev * dev) / (n * pmale * (1 - pmale))
+}
+
 // Same as ChiSqTrio, but using the extended chi squared approach (not used here)
 func ChiSqExtended(i, j, h float64) float64 {
 	return 4 * (i - j) * (i - j) / h
@@ -475,6 +482,7 @@ type TDTResult struct {
 	Totals             Family
 	Nfamilies          float64
 	MaleProportion     float64
+	ExpectedMaleProp   float64
 	MeanMalesPerFam    float64
 	MeanFemalesPerFam  float64
 	MeanChildrenPerFam float64
@@ -484,11 +492,17 @@ type TDTResult struct {
 }
 
 func TDTTest(fams ...Family) TDTResult {
+	return TDTTestRatio(0.5, fams...)
+}
+
+// Run the TDT test against an expected male fraction of pmale
+func TDTTestRatio(pmale float64, fams ...Family) TDTResult {
 	var r TDTResult
 
 	r.Totals = CondenseFamilies(fams...)
-	r.Chisq = ChiSqTrio(r.Totals.MaleF1, r.Totals.FemaleF1)
+	r.Chisq = ChiSqTrioRatio(r.Totals.MaleF1, r.Totals.FemaleF1, pmale)
 	r.MaleProportion = r.Totals.MaleF1 / (r.Totals.MaleF1 + r.Totals.FemaleF1)
+	r.ExpectedMaleProp = pmale
 
 	dist := distuv.ChiSquared{K: 1}
 	r.P = 1 - dist.CDF(math.Abs(r.Chisq))
@@ -508,6 +522,7 @@ type TDTResultJson struct {
 	TotalFemales       any
 	Nfamilies          any
 	MaleProportion     any
+	ExpectedMaleProp   any
 	MeanMalesPerFam    any
 	MeanFemalesPerFam  any
 	MeanChildrenPerFam any
@@ -550,6 +565,7 @@ func ToJson(r TDTResult) TDTResultJson {
 	j.TotalFemales = FloatToJson(r.Totals.FemaleF1)
 	j.Nfamilies = FloatToJson(r.Nfamilies)
 	j.MaleProportion = FloatToJson(r.MaleProportion)
+	j.ExpectedMaleProp = FloatToJson(r.ExpectedMaleProp)
 	j.MeanMalesPerFam = FloatToJson(r.MeanMalesPerFam)
 	j.MeanFemalesPerFam = FloatToJson(r.MeanFemalesPerFam)
 	j.MeanChildrenPerFam = FloatToJson(r.MeanChildrenPerFam)
@@ -566,6 +582,11 @@ func FromJson(r TDTResultJson) TDTResult {
 	j.Totals.FemaleF1 = JsonToFloat(r.TotalFemales)
 	j.Nfamilies = JsonToFloat(r.Nfamilies)
 	j.MaleProportion = JsonToFloat(r.MaleProportion)
+	// Results written before the expected ratio was configurable were tested against 0.5
+	j.ExpectedMaleProp = 0.5
+	if r.ExpectedMaleProp != nil {
+		j.ExpectedMaleProp = JsonToFloat(r.ExpectedMaleProp)
+	}
 	j.MeanMalesPerFam = JsonToFloat(r.MeanMalesPerFam)
 	j.MeanFemalesPerFam = JsonToFloat(r.MeanFemalesPerFam)
 	j.MeanChildrenPerFam = JsonToFloat(r.MeanChildrenPerFam)
@@ -605,34 +626,42 @@ func fullTDTTestOld() {
 }
 
 func FullTDTTest() {
+	var x ExpectedRatio
 	focal := flag.Int("f", -1, "focal ID (required)")
+	RegisterExpectedRatioFlags(&x)
 	flag.Parse()
 	if *focal == -1 {
 		panic(fmt.Errorf("missing -f"))
 	}
+	Must(x.Validate())
 
 	peds, e := ParsePedFromReader(os.Stdin)
 	Must(e)
+	var ip *IndexedPed
+	if x.FromPed {
+		ip = NewIndexedPed(BuildPedTree(peds...))
+	}
+	focalID := fmt.Sprint(*focal)
 
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "\t")
 
-	res := TDTTest(BuildFamiliesFemaleX(fmt.Sprint(*focal), peds...)...)
+	res := TDTTestRatio(x.For(ip, LineageX, focalID), BuildFamiliesFemaleX(focalID, peds...)...)
 	res.Name = "FemaleX"
 	err := enc.Encode(ToJson(res))
 	Must(err)
 
-	res = TDTTest(BuildFamiliesFemDescentFemaleX(fmt.Sprint(*focal), peds...)...)
+	res = TDTTestRatio(x.For(ip, LineageX, focalID), BuildFamiliesFemDescentFemaleX(focalID, peds...)...)
 	res.Name = "FemDescentFemaleX"
 	err = enc.Encode(ToJson(res))
 	Must(err)
 
-	res = TDTTest(BuildFamiliesY(fmt.Sprint(*focal), peds...)...)
+	res = TDTTestRatio(x.For(ip, LineageY, focalID), BuildFamiliesY(focalID, peds...)...)
 	res.Name = "Y"
 	err = enc.Encode(ToJson(res))
 	Must(err)
 
-	res = TDTTest(BuildFamiliesAuto(fmt.Sprint(*focal), peds...)...)
+	res = TDTTestRatio(x.For(ip, LineageAuto, focalID), BuildFamiliesAuto(focalID, peds...)...)
 	res.Name = "Auto"
 	err = enc.Encode(ToJson(res))
 	Must(err)
@@ -640,14 +669,21 @@ func FullTDTTest() {
 
 // Run Multi-Y TDT test on the command line
 func FullMultiYTDTTest() {
+	var x ExpectedRatio
 	focalPath := flag.String("f", "", "path to line-separated IDs for focal individuals")
+	RegisterExpectedRatioFlags(&x)
 	flag.Parse()
 	if *focalPath == "" {
 		log.Fatal(fmt.Errorf("missing -f"))
 	}
+	Must(x.Validate())
 
 	peds, e := ParsePedFromReader(os.Stdin)
 	Must(e)
+	var ip *IndexedPed
+	if x.FromPed {
+		ip = NewIndexedPed(BuildPedTree(peds...))
+	}
 
 	w := bufio.NewWriter(os.Stdout)
 	defer func() {
@@ -663,7 +699,7 @@ func FullMultiYTDTTest() {
 	Must(e)
 
 	for _, f := range focals {
-		res := TDTTest(BuildFamiliesY(f, peds...)...)
+		res := TDTTestRatio(x.For(ip, LineageY, f), BuildFamiliesY(f, peds...)...)
 		res.Name = fmt.Sprint(f)
 		err := enc.Encode(ToJson(res))
 		Must(err)
@@ -676,6 +712,8 @@ func FullAllYTDTTest() {
 	outPath := flag.String("o", "", "path to write output")
 	focalID := flag.String("f", "", "IndividualID for focal individual (default is to do TDT for all males)")
 	fakeName := flag.Bool("n", false, "Use fake names instead of real ones")
+	var x ExpectedRatio
+	RegisterExpectedRatioFlags(&x)
 	flag.Parse()
 	if *pedPath == "" {
 		log.Fatal(fmt.Errorf("missing -i"))
@@ -683,12 +721,17 @@ func FullAllYTDTTest() {
 	if *outPath == "" {
 		log.Fatal(fmt.Errorf("missing -o"))
 	}
+	Must(x.Validate())
 
 	r, e := csvh.OpenMaybeGz(*pedPath)
 	Must(e)
 	defer r.Close()
 	peds, e := ParsePedSafe(r)
 	Must(e)
+	var ip *IndexedPed
+	if x.FromPed {
+		ip = NewIndexedPed(BuildPedTree(peds...))
+	}
 
 	ww, e := csvh.CreateMaybeGz(*outPath)
 	Must(e)
@@ -707,7 +750,7 @@ func FullAllYTDTTest() {
 
 		i := 0
 		for _, f := range orphanFocal {
-			res := TDTTest(BuildFamiliesY(f.IndividualID, peds...)...)
+			res := TDTTestRatio(x.For(ip, LineageY, f.IndividualID), BuildFamiliesY(f.IndividualID, peds...)...)
 			if *fakeName {
 				res.Name = fmt.Sprint(i)
 			} else {
@@ -720,7 +763,7 @@ func FullAllYTDTTest() {
 		}
 
 		for _, f := range nonOrphanFocal {
-			res := TDTTest(BuildFamiliesY(f.IndividualID, peds...)...)
+			res := TDTTestRatio(x.For(ip, LineageY, f.IndividualID), BuildFamiliesY(f.IndividualID, peds...)...)
 			if *fakeName {
 				res.Name = fmt.Sprint(i)
 			} else {
@@ -732,7 +775,7 @@ func FullAllYTDTTest() {
 			i++
 		}
 	} else {
-		res := TDTTest(BuildFamiliesY(*focalID, peds...)...)
+		res := TDTTestRatio(x.For(ip, LineageY, *focalID), BuildFamiliesY(*focalID, peds...)...)
 		if *fakeName {
 			res.Name = "0"
 		} else {
@@ -0,0 +1,446 @@
+package tdt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"maps"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+// A WARP cache file stores parsed entries column by column, little-endian:
+//
+//	magic "TDTWARPC", version uint32, entry count uint64
+//	dictionary: string count uint32, then each string as a uint32 length and its bytes
+//	string columns (family, ind, father, mother, sex, phenotype, geno_risk): one uint32 dictionary index per entry
+//	float columns (prior, posterior, pheno_risk): one float64 per entry
+//	attributes: count uint32, then each as a uint32 dictionary index of its name and one index per entry
+//
+// Attribute values absent from an entry have index warpCacheAbsent.
+const (
+	warpCacheMagic   = "TDTWARPC"
+	warpCacheVersion = 1
+	warpCacheAbsent  = math.MaxUint32
+)
+
+// Builds the string dictionary of a cache
+type stringDict struct {
+	index   map[string]uint32
+	strings []string
+}
+
+func (d *stringDict) add(s string) uint32 {
+	if i, ok := d.index[s]; ok {
+		return i
+	}
+	i := uint32(len(d.strings))
+	d.index[s] = i
+	d.strings = append(d.strings, s)
+	return i
+}
+
+// The string and float columns of a cache, in file order
+var warpCacheStrings = []func(*Entry) *string{
+	func(ent *Entry) *string { return &ent.FamilyID },
+	func(ent *Entry) *string { return &ent.IndividualID },
+	func(ent *Entry) *string { return &ent.FatherID },
+	func(ent *Entry) *string { return &ent.MotherID },
+	func(ent *Entry) *string { return &ent.Sex },
+	func(ent *Entry) *string { return &ent.Phenotype },
+	func(ent *Entry) *string { return &ent.GenoRisk },
+}
+
+var warpCacheFloats = []func(*Entry) *float64{
+	func(ent *Entry) *float64 { return &ent.Prior },
+	func(ent *Entry) *float64 { return &ent.Posterior },
+	func(ent *Entry) *float64 { return &ent.PhenoRisk },
+}
+
+// Write entries as a WARP cache
+func WriteWarpCache(w io.Writer, ents []Entry) error {
+	dict := stringDict{index: map[string]uint32{}}
+	strCols := make([][]uint32, len(warpCacheStrings))
+	floatCols := make([][]float64, len(warpCacheFloats))
+	attrCols := map[string][]uint32{}
+	var attrNames []string
+
+	for i := range ents {
+		for j, field := range warpCacheStrings {
+			strCols[j] = append(strCols[j], dict.add(*field(&ents[i])))
+		}
+		for j, field := range warpCacheFloats {
+			floatCols[j] = append(floatCols[j], *field(&ents[i]))
+		}
+		for _, name := range slices.Sorted(maps.Keys(ents[i].Attrs)) {
+			if _, ok := attrCols[name]; !ok {
+				attrNames = append(attrNames, name)
+				col := make([]uint32, len(ents))
+				for k := range col {
+					col[k] = warpCacheAbsent
+				}
+				attrCols[name] = col
+			}
+			attrCols[name][i] = dict.add(ents[i].Attrs[name])
+		}
+	}
+	attrNameIdx := make([]uint32, len(attrNames))
+	for i, name := range attrNames {
+		attrNameIdx[i] = dict.add(name)
+	}
+
+	bw := bufio.NewWriter(w)
+	write := func(data any) error {
+		return binary.Write(bw, binary.LittleEndian, data)
+	}
+	if _, e := bw.WriteString(warpCacheMagic); e != nil {
+		return e
+	}
+	if e := write(uint32(warpCacheVersion)); e != nil {
+		return e
+	}
+	if e := write(uint64(len(ents))); e != nil {
+		return e
+	}
+	if e := write(uint32(len(dict.strings))); e != nil {
+		return e
+	}
+	for _, s := range dict.strings {
+		if e := write(uint32(len(s))); e != nil {
+			return e
+		}
+		if _, e := bw.WriteString(s); e != nil {
+			return e
+		}
+	}
+	for _, col := range strCols {
+		if e := write(col); e != nil {
+			return e
+		}
+	}
+	for _, col := range floatCols {
+		if e := write(col); e != nil {
+			return e
+		}
+	}
+	if e := write(uint32(len(attrNames))); e != nil {
+		return e
+	}
+	for i, name := range attrNames {
+		if e := write(attrNameIdx[i]); e != nil {
+			return e
+		}
+		if e := write(attrCols[name]); e != nil {
+			return e
+		}
+	}
+	return bw.Flush()
+}
+
+// Write entries to a WARP cache file, gzipped if the path ends in .gz
+func WriteWarpCachePath(path string, ents []Entry) (err error) {
+	w, e := csvh.CreateMaybeGz(path)
+	if e != nil {
+		return e
+	}
+	defer func() { csvh.DeferE(&err, w.Close()) }()
+	return WriteWarpCache(w, ents)
+}
+
+// Read count little-endian values in chunks, so that a corrupt count fails at
+// the end of the input instead of allocating memory for values that are not there
+func readCacheColumn[T uint32 | float64](r io.Reader, count uint64) ([]T, error) {
+	const chunk = 1 << 16
+	var out []T
+	buf := make([]T, min(count, chunk))
+	for left := count; left > 0; {
+		k := min(left, chunk)
+		if e := binary.Read(r, binary.LittleEndian, buf[:k]); e != nil {
+			return nil, e
+		}
+		out = append(out, buf[:k]...)
+		left -= k
+	}
+	return out, nil
+}
+
+// Read a string of l bytes, growing the buffer only as bytes arrive
+func readCacheString(r io.Reader, l uint32) (string, error) {
+	var b bytes.Buffer
+	if _, e := io.CopyN(&b, r, int64(l)); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return "", e
+	}
+	return b.String(), nil
+}
+
+// Read a WARP cache, including its magic bytes. The columnar layout means the
+// whole cache is held in memory at once, unlike text WARP output, which
+// ParsePed streams. Counts in the file are checked against the data actually
+// present, so a corrupt cache returns an error rather than exhausting memory.
+func ReadWarpCache(r io.Reader) ([]Entry, error) {
+	h := csvh.Handle1[[]Entry]("ReadWarpCache: %w")
+	br := bufio.NewReader(r)
+	read := func(data any) error {
+		return binary.Read(br, binary.LittleEndian, data)
+	}
+
+	magic := make([]byte, len(warpCacheMagic))
+	if _, e := io.ReadFull(br, magic); e != nil {
+		return h(e)
+	}
+	if string(magic) != warpCacheMagic {
+		return h(fmt.Errorf("not a WARP cache"))
+	}
+	var version uint32
+	if e := read(&version); e != nil {
+		return h(e)
+	}
+	if version != warpCacheVersion {
+		return h(fmt.Errorf("unsupported version %v", version))
+	}
+	var n uint64
+	if e := read(&n); e != nil {
+		return h(e)
+	}
+	if n > math.MaxInt {
+		return h(fmt.Errorf("entry count %v too large", n))
+	}
+
+	var ndict uint32
+	if e := read(&ndict); e != nil {
+		return h(e)
+	}
+	var dict []string
+	for i := uint32(0); i < ndict; i++ {
+		var l uint32
+		if e := read(&l); e != nil {
+			return h(e)
+		}
+		s, e := readCacheString(br, l)
+		if e != nil {
+			return h(e)
+		}
+		dict = append(dict, s)
+	}
+	lookup := func(idx uint32) (string, error) {
+		if int64(idx) >= int64(len(dict)) {
+			return "", fmt.Errorf("string index %v out of range of %v", idx, len(dict))
+		}
+		return dict[idx], nil
+	}
+
+	var ents []Entry
+	for _, field := range warpCacheStrings {
+		col, e := readCacheColumn[uint32](br, n)
+		if e != nil {
+			return h(e)
+		}
+		if ents == nil {
+			ents = make([]Entry, n)
+		}
+		for i := range ents {
+			s, e := lookup(col[i])
+			if e != nil {
+				return h(e)
+			}
+			*field(&ents[i]) = s
+		}
+	}
+	for _, field := range warpCacheFloats {
+		fcol, e := readCacheColumn[float64](br, n)
+		if e != nil {
+			return h(e)
+		}
+		for i := range ents {
+			*field(&ents[i]) = fcol[i]
+		}
+	}
+
+	var nattr uint32
+	if e := read(&nattr); e != nil {
+		return h(e)
+	}
+	for j := uint32(0); j < nattr; j++ {
+		var nameIdx uint32
+		if e := read(&nameIdx); e != nil {
+			return h(e)
+		}
+		name, e := lookup(nameIdx)
+		if e != nil {
+			return h(e)
+		}
+		col, e := readCacheColumn[uint32](br, n)
+		if e != nil {
+			return h(e)
+		}
+		for i, idx := range col {
+			if idx == warpCacheAbsent {
+				continue
+			}
+			val, e := lookup(idx)
+			if e != nil {
+				return h(e)
+			}
+			if ents[i].Attrs == nil {
+				ents[i].Attrs = map[string]string{}
+			}
+			ents[i].Attrs[name] = val
+		}
+	}
+	return ents, nil
+}
+
+// Check whether a reader starts with the WARP cache magic bytes, without consuming them
+func IsWarpCache(br *bufio.Reader) bool {
+	b, _ := br.Peek(len(warpCacheMagic))
+	return bytes.Equal(b, []byte(warpCacheMagic))
+}
+
+// Iterate over the entries of a WARP cache. The whole cache is read before the
+// first entry is yielded.
+func WarpCacheEntries(r io.Reader) iter.Seq2[Entry, error] {
+	return func(y func(Entry, error) bool) {
+		ents, e := ReadWarpCache(r)
+		if e != nil {
+			y(Entry{}, e)
+			return
+		}
+		for _, ent := range ents {
+			if !y(ent, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Convert a WARP output file, or a cache, to a WARP cache file
+func ConvertWarpCache(inPath, outPath string, header bool) error {
+	ents, e := iterh.CollectWithError(ParsePedPath(inPath, header))
+	if e != nil {
+		return fmt.Errorf("ConvertWarpCache: %w", e)
+	}
+	if e := WriteWarpCachePath(outPath, ents); e != nil {
+		return fmt.Errorf("ConvertWarpCache: %v: %w", outPath, e)
+	}
+	return nil
+}
+
+// Convert each input path to the output path at the same index, on nworkers goroutines
+func ConvertWarpCaches(inPaths, outPaths []string, header bool, nworkers int) error {
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	errs := make([]error, len(inPaths))
+	jobs := make(chan int, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = ConvertWarpCache(inPaths[i], outPaths[i], header)
+			}
+		}()
+	}
+	for i := range inPaths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Flags for FullWarpCache
+type WarpCacheFlags struct {
+	InPath      string
+	OutPath     string
+	Header      bool
+	BgPathsPath string
+	OutList     string
+	Suffix      string
+	Workers     int
+}
+
+// Write the converted background paths in the same form as the input list: a
+// manifest with its Output paths replaced, or a plain list
+func writeCacheList(inList, outList string, outPaths []string) (err error) {
+	isManifest, e := IsManifestPath(inList)
+	if e != nil {
+		return e
+	}
+	if isManifest {
+		m, e := ReadManifestPath(inList)
+		if e != nil {
+			return e
+		}
+		for i := range m.Replicates {
+			m.Replicates[i].Output = outPaths[i]
+		}
+		return WriteOutput(outList, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			return enc.Encode(m)
+		})
+	}
+	return WriteOutput(outList, func(w io.Writer) error {
+		for _, path := range outPaths {
+			if _, e := fmt.Fprintln(w, path); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+// Run on the command line
+func FullWarpCache() {
+	var f WarpCacheFlags
+	flag.StringVar(&f.InPath, "i", "", "Path to one WARP output file to convert")
+	flag.StringVar(&f.OutPath, "o", "", "Path to write the cache of -i")
+	flag.BoolVar(&f.Header, "h", false, "WARP output has a header line")
+	flag.StringVar(&f.BgPathsPath, "b", "", "Path to a list of WARP output paths, or a pedshufsex replicate manifest, to convert")
+	flag.StringVar(&f.OutList, "ol", "", "Path to write the list or manifest of converted -b paths (default stdout)")
+	flag.StringVar(&f.Suffix, "s", ".tdtc", "Suffix added to each -b path to name its cache")
+	flag.IntVar(&f.Workers, "w", runtime.NumCPU(), "Number of files to convert in parallel")
+	flag.Parse()
+
+	if (f.InPath == "") == (f.BgPathsPath == "") {
+		log.Fatal(fmt.Errorf("give exactly one of -i and -b"))
+	}
+	if f.InPath != "" {
+		if f.OutPath == "" {
+			log.Fatal(fmt.Errorf("missing -o"))
+		}
+		Must(ConvertWarpCache(f.InPath, f.OutPath, f.Header))
+		return
+	}
+
+	inPaths, e := BackgroundPaths(f.BgPathsPath)
+	Must(e)
+	outPaths := make([]string, 0, len(inPaths))
+	for _, path := range inPaths {
+		outPaths = append(outPaths, path+f.Suffix)
+	}
+	Must(ConvertWarpCaches(inPaths, outPaths, f.Header, f.Workers))
+	Must(writeCacheList(f.BgPathsPath, f.OutList, outPaths))
+}
@@ -0,0 +1,98 @@
+package tdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+func TestWarpCache(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		text   string
+		header bool
+	}{
+		{"plain", exampleWarp, false},
+		{"header", exampleWarpHeader, true},
+	} {
+		in := filepath.Join(dir, tc.name+".txt")
+		if e := os.WriteFile(in, []byte(strings.Replace(tc.text, "banana", "0.1", -1)), 0644); e != nil {
+			t.Fatal(e)
+		}
+		want, e := iterh.CollectWithError(ParsePedPath(in, tc.header))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		for _, out := range []string{filepath.Join(dir, tc.name+".tdtc"), filepath.Join(dir, tc.name+".tdtc.gz")} {
+			if e := ConvertWarpCache(in, out, tc.header); e != nil {
+				t.Fatal(e)
+			}
+			// the header flag is ignored for caches
+			got, e := iterh.CollectWithError(ParsePedPath(out, !tc.header))
+			if e != nil {
+				t.Fatal(e)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("%v: %v entries != %v", out, len(got), len(want))
+			}
+			for i := range got {
+				g, w := got[i], want[i]
+				// NaN pheno risks do not compare equal
+				if math.IsNaN(g.PhenoRisk) && math.IsNaN(w.PhenoRisk) {
+					g.PhenoRisk, w.PhenoRisk = 0, 0
+				}
+				if !reflect.DeepEqual(g, w) {
+					t.Errorf("%v: entry %v: %#v != %#v", out, i, g, w)
+				}
+			}
+			best, e := GetBiggestOutlierPath(out, !tc.header)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if wbest := GetBiggestOutlier(slices.Values(want)); best.IndividualID != wbest.IndividualID {
+				t.Errorf("%v: biggest outlier %v != %v", out, best.IndividualID, wbest.IndividualID)
+			}
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.tdtc")
+	if e := os.WriteFile(bad, []byte(warpCacheMagic+"\x01\x00\x00\x00\x05"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := iterh.CollectWithError(ParsePedPath(bad, false)); e == nil {
+		t.Errorf("truncated cache did not fail")
+	}
+}
+
+func TestWarpCacheCorruptCounts(t *testing.T) {
+	le := func(v any) string {
+		var b bytes.Buffer
+		if e := binary.Write(&b, binary.LittleEndian, v); e != nil {
+			t.Fatal(e)
+		}
+		return b.String()
+	}
+	head := warpCacheMagic + le(uint32(warpCacheVersion))
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"entries", head + le(uint64(1)<<62) + le(uint32(0))},
+		{"dictionary", head + le(uint64(1)) + le(uint32(math.MaxUint32))},
+		{"string", head + le(uint64(1)) + le(uint32(1)) + le(uint32(math.MaxUint32)) + "abc"},
+	} {
+		if _, e := ReadWarpCache(strings.NewReader(tc.data)); e == nil {
+			t.Errorf("%v: corrupt count did not fail", tc.name)
+		}
+	}
+}
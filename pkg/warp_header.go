@@ -0,0 +1,177 @@
+package tdt
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Column names of WARP output, as written in its header line
+const (
+	WarpFamily    = "family"
+	WarpInd       = "ind"
+	WarpFather    = "father"
+	WarpMother    = "mother"
+	WarpSex       = "sex"
+	WarpPhenotype = "phenotype"
+	WarpPrior     = "prior"
+	WarpPosterior = "posterior"
+	WarpPhenoRisk = "pheno_risk"
+	WarpGenoRisk  = "geno_risk"
+)
+
+// Columns that every header must have, in WARP's order
+var WarpRequiredColumns = []string{WarpFamily, WarpInd, WarpFather, WarpMother, WarpSex, WarpPhenotype, WarpPrior, WarpPosterior}
+
+// Columns that are filled in when present: PhenoRisk is NaN and GenoRisk empty otherwise
+var WarpOptionalColumns = []string{WarpPhenoRisk, WarpGenoRisk}
+
+// Other names that annotated pedigrees use for the WARP columns
+var warpColumnAliases = map[string]string{
+	"fid":          WarpFamily,
+	"familyid":     WarpFamily,
+	"iid":          WarpInd,
+	"individual":   WarpInd,
+	"individualid": WarpInd,
+	"paternalid":   WarpFather,
+	"maternalid":   WarpMother,
+	"phenorisk":    WarpPhenoRisk,
+	"genorisk":     WarpGenoRisk,
+}
+
+// Normalize a header name: trim space and a leading '#', lowercase, and resolve aliases
+func normalizeWarpColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "#")))
+	if canon, ok := warpColumnAliases[name]; ok {
+		return canon
+	}
+	return name
+}
+
+// An attribute column that is not one of the WARP columns
+type attrColumn struct {
+	Name  string
+	Index int
+}
+
+// The position of each column, found from a header line. MaxIndex is the
+// largest position of a named column, so shorter lines cannot be parsed.
+// WarpOrder is set if the header names WARP's ten columns in WARP's order,
+// without a field for the unnamed gap column.
+type WarpHeader struct {
+	Columns   map[string]int
+	Attrs     []attrColumn
+	NCols     int
+	MaxIndex  int
+	WarpOrder bool
+}
+
+// Find the WARP columns in a header line by name. Columns with other names
+// are kept as attributes, and unnamed columns (such as WARP's blank column
+// before prior) are ignored. A header line may also leave out the blank
+// column entirely; see WarpHeader.Parse.
+func ParseWarpHeader(header []string) (WarpHeader, error) {
+	h := WarpHeader{Columns: map[string]int{}, NCols: len(header)}
+	known := map[string]bool{}
+	for _, c := range WarpRequiredColumns {
+		known[c] = true
+	}
+	for _, c := range WarpOptionalColumns {
+		known[c] = true
+	}
+
+	for i, raw := range header {
+		name := normalizeWarpColumn(raw)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			h.Attrs = append(h.Attrs, attrColumn{Name: strings.TrimSpace(raw), Index: i})
+			continue
+		}
+		if j, ok := h.Columns[name]; ok {
+			return h, fmt.Errorf("ParseWarpHeader: column %q appears twice, at columns %v and %v", name, j+1, i+1)
+		}
+		h.Columns[name] = i
+	}
+	for _, i := range h.Columns {
+		h.MaxIndex = max(h.MaxIndex, i)
+	}
+	for _, a := range h.Attrs {
+		h.MaxIndex = max(h.MaxIndex, a.Index)
+	}
+	warpOrder := append(slices.Clone(WarpRequiredColumns), WarpOptionalColumns...)
+	h.WarpOrder = len(header) == len(warpOrder)
+	for i, c := range warpOrder {
+		h.WarpOrder = h.WarpOrder && h.Columns[c] == i
+	}
+
+	var missing []string
+	for _, c := range WarpRequiredColumns {
+		if _, ok := h.Columns[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return h, fmt.Errorf("ParseWarpHeader: missing required column(s) %v in header %q", strings.Join(missing, ", "), header)
+	}
+	return h, nil
+}
+
+// Parse one line using the header's column positions. Fields after the last
+// named column are ignored. If the header is in WARP's order without the gap
+// column, and the line has one more field than it, the line is WARP's own
+// layout and is parsed by position, as ParseLineToEntry does.
+func (h WarpHeader) Parse(line []string) (Entry, error) {
+	var ent Entry
+	if h.WarpOrder && len(line) == h.NCols+1 {
+		return ParseLineToEntry(line)
+	}
+	if len(line) <= h.MaxIndex {
+		return ent, fmt.Errorf("%w: %v columns, but the header names column %v", ParseError, len(line), h.MaxIndex+1)
+	}
+	get := func(name string) string {
+		return line[h.Columns[name]]
+	}
+	parseF := func(name string) (float64, error) {
+		f, e := strconv.ParseFloat(strings.TrimSpace(get(name)), 64)
+		if e != nil {
+			return 0, fmt.Errorf("%w: column %q (column %v): %w", ParseError, name, h.Columns[name]+1, e)
+		}
+		return f, nil
+	}
+
+	ent.FamilyID = get(WarpFamily)
+	ent.IndividualID = get(WarpInd)
+	ent.FatherID = get(WarpFather)
+	ent.MotherID = get(WarpMother)
+	ent.Sex = get(WarpSex)
+	ent.Phenotype = get(WarpPhenotype)
+
+	var e error
+	if ent.Prior, e = parseF(WarpPrior); e != nil {
+		return ent, e
+	}
+	if ent.Posterior, e = parseF(WarpPosterior); e != nil {
+		return ent, e
+	}
+	ent.PhenoRisk = math.NaN()
+	if _, ok := h.Columns[WarpPhenoRisk]; ok {
+		if ent.PhenoRisk, e = parseF(WarpPhenoRisk); e != nil {
+			return ent, e
+		}
+	}
+	if _, ok := h.Columns[WarpGenoRisk]; ok {
+		ent.GenoRisk = get(WarpGenoRisk)
+	}
+
+	if len(h.Attrs) > 0 {
+		ent.Attrs = make(map[string]string, len(h.Attrs))
+		for _, a := range h.Attrs {
+			ent.Attrs[a.Name] = line[a.Index]
+		}
+	}
+	return ent, nil
+}
@@ -0,0 +1,79 @@
+package tdt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+const exampleWarpHeader = `#ind	family	father	mother	sex	phenotype	batch	posterior	prior	geno_risk
+3	1	1	2	1	1	b7	0.5	0.3	banana
+4	1	1	2	1	1	b8	0.4	0.3	0.25`
+
+func TestParsePedHeader(t *testing.T) {
+	ents, e := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarpHeader), true))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(ents) != 2 {
+		t.Fatalf("%v entries != 2", len(ents))
+	}
+	ent := ents[1]
+	if ent.IndividualID != "4" || ent.FamilyID != "1" || ent.Posterior != 0.4 || ent.Prior != 0.3 || ent.GenoRisk != "0.25" {
+		t.Errorf("bad entry %#v", ent)
+	}
+	if ent.Attrs["batch"] != "b8" {
+		t.Errorf("attrs %v", ent.Attrs)
+	}
+
+	missing := strings.Replace(exampleWarpHeader, "prior", "priors", 1)
+	_, e = iterh.CollectWithError(ParsePed(strings.NewReader(missing), true))
+	if e == nil || !strings.Contains(e.Error(), "missing required column(s) prior") {
+		t.Errorf("expected missing prior error, got %v", e)
+	}
+
+	bad := strings.Replace(exampleWarpHeader, "0.4", "x", 1)
+	_, e = iterh.CollectWithError(ParsePed(strings.NewReader(bad), true))
+	if e == nil || !strings.Contains(e.Error(), `column "posterior"`) {
+		t.Errorf("expected posterior parse error, got %v", e)
+	}
+}
+
+func TestParsePedWarpHeader(t *testing.T) {
+	want, e := iterh.CollectWithError(ParsePed(strings.NewReader(exampleWarp), false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, tc := range []struct {
+		name   string
+		header string
+	}{
+		// WARP's header names ten columns over eleven-field rows, leaving out the gap
+		{"warp", "family\tind\tfather\tmother\tsex\tphenotype\tprior\tposterior\tpheno_risk\tgeno_risk"},
+		{"blank gap", "family\tind\tfather\tmother\tsex\tphenotype\t\tprior\tposterior\tpheno_risk\tgeno_risk"},
+	} {
+		got, e := iterh.CollectWithError(ParsePed(strings.NewReader(tc.header+"\n"+exampleWarp), true))
+		if e != nil {
+			t.Fatalf("%v: %v", tc.name, e)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%v: %v entries != %v", tc.name, len(got), len(want))
+		}
+		for i := range got {
+			if g, w := got[i], want[i]; g.IndividualID != w.IndividualID || g.Prior != w.Prior || g.Posterior != w.Posterior || g.PhenoRisk != w.PhenoRisk || g.GenoRisk != w.GenoRisk {
+				t.Errorf("%v: entry %v: %#v != %#v", tc.name, i, g, w)
+			}
+		}
+	}
+
+	// fields after the last named column are ignored, but lines missing a named column fail
+	extra := strings.Replace(exampleWarpHeader, "banana", "banana\textra", 1)
+	if _, e := iterh.CollectWithError(ParsePed(strings.NewReader(extra), true)); e != nil {
+		t.Errorf("extra trailing field: %v", e)
+	}
+	short := strings.Replace(exampleWarpHeader, "\tbanana", "", 1)
+	if _, e := iterh.CollectWithError(ParsePed(strings.NewReader(short), true)); e == nil {
+		t.Errorf("no error for a line missing geno_risk")
+	}
+}
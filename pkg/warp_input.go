@@ -0,0 +1,255 @@
+package tdt
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"github.com/jgbaldwinbrown/iterh"
+)
+
+// How prior probabilities are assigned to individuals
+type PriorMode string
+
+const (
+	PriorUniform PriorMode = "uniform" // every individual gets the same prior
+	PriorFounder PriorMode = "founder" // founders share the prior, and everyone else gets 0
+	PriorTable   PriorMode = "table"   // priors are read from a table of individual IDs
+)
+
+func (m PriorMode) Validate() error {
+	switch m {
+	case PriorUniform, PriorFounder, PriorTable:
+		return nil
+	}
+	return fmt.Errorf("unknown prior mode %q; choose uniform, founder or table", string(m))
+}
+
+// Read a table of priors with columns individual ID and prior, or family ID,
+// individual ID and prior. If header is set, the first line is skipped.
+func ReadPriorTable(path string, header bool) (map[string]float64, error) {
+	h := csvh.Handle1[map[string]float64]("ReadPriorTable: %w")
+	out := map[string]float64{}
+	lineNum := 0
+	for line, e := range iterh.PathIter(path, iterh.LineIter) {
+		if e != nil {
+			return h(e)
+		}
+		lineNum++
+		if header && lineNum == 1 {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 && len(fields) != 3 {
+			return h(fmt.Errorf("line %v has %v columns, not 2 or 3", lineNum, len(fields)))
+		}
+		prior, e := strconv.ParseFloat(strings.TrimSpace(fields[len(fields)-1]), 64)
+		if e != nil {
+			return h(fmt.Errorf("line %v: %w", lineNum, e))
+		}
+		out[fields[len(fields)-2]] = prior
+	}
+	return out, nil
+}
+
+// Assign a prior to every individual in ps. With value <= 0, uniform priors
+// are 1/N and founder priors 1/(number of founders), so priors sum to 1.
+// Founders are individuals with neither parent in the pedigree. Individuals
+// missing from table get value, or 0 if value <= 0.
+func AssignPriors(ps []PedEntry, mode PriorMode, value float64, table map[string]float64) (map[string]float64, error) {
+	out := make(map[string]float64, len(ps))
+	switch mode {
+	case PriorUniform:
+		p := value
+		if p <= 0 {
+			p = 1 / float64(len(ps))
+		}
+		for _, ent := range ps {
+			out[ent.IndividualID] = p
+		}
+	case PriorFounder:
+		gens := Generations(BuildPedTree(ps...))
+		nfounders := 0
+		for _, ent := range ps {
+			if gens[ent.IndividualID] == 0 {
+				nfounders++
+			}
+		}
+		if nfounders < 1 {
+			return nil, fmt.Errorf("AssignPriors: pedigree has no founders")
+		}
+		p := value
+		if p <= 0 {
+			p = 1 / float64(nfounders)
+		}
+		for _, ent := range ps {
+			if gens[ent.IndividualID] == 0 {
+				out[ent.IndividualID] = p
+			} else {
+				out[ent.IndividualID] = 0
+			}
+		}
+	case PriorTable:
+		for _, ent := range ps {
+			p, ok := table[ent.IndividualID]
+			if !ok {
+				p = max(value, 0)
+			}
+			out[ent.IndividualID] = p
+		}
+	default:
+		return nil, mode.Validate()
+	}
+	return out, nil
+}
+
+// For each pedigree entry, list the family ID, individual ID and prior
+func WritePriors(w io.Writer, ps []PedEntry, priors map[string]float64) error {
+	if _, e := fmt.Fprintf(w, "fam\tind\tprior\n"); e != nil {
+		return e
+	}
+	for _, p := range ps {
+		if _, e := fmt.Fprintf(w, "%v\t%v\t%v\n", p.FamilyID, p.IndividualID, priors[p.IndividualID]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Create a file at path and run WritePriors on it
+func WritePriorsPath(path string, ps []PedEntry, priors map[string]float64) (err error) {
+	w, e := csvh.CreateMaybeGz(path)
+	if e != nil {
+		return e
+	}
+	defer func() { csvh.DeferE(&err, w.Close()) }()
+	return WritePriors(w, ps, priors)
+}
+
+// The files of one WARP run: its three inputs, and where its output should go
+type WarpInputPaths struct {
+	Ped     string
+	SexGeno string
+	Prior   string
+	Output  string
+}
+
+// The paths of the WARP run named name ("real", or a replicate number) under prefix
+func WarpInputPathsFor(prefix, name string) WarpInputPaths {
+	base := prefix + "_" + name
+	return WarpInputPaths{
+		Ped:     base + ".ped",
+		SexGeno: base + ".sexgeno.txt",
+		Prior:   base + ".prior.txt",
+		Output:  base + ".warp.txt",
+	}
+}
+
+// Write the pedigree, sex genotypes and priors of one WARP run. If sexPheno
+// is set, each phenotype is replaced by the sex, as with SexPheno.
+func WriteWarpInputs(paths WarpInputPaths, ps []PedEntry, priors map[string]float64, sexPheno bool) error {
+	if sexPheno {
+		ps = slices.Clone(ps)
+		for i := range ps {
+			SexPheno(&ps[i])
+		}
+	}
+	if e := WritePedPath(paths.Ped, ps); e != nil {
+		return e
+	}
+	if e := WriteSexGenosPath(paths.SexGeno, ps...); e != nil {
+		return e
+	}
+	return WritePriorsPath(paths.Prior, ps, priors)
+}
+
+// Flags for FullWarpInput
+type WarpInputFlags struct {
+	Inpath     string
+	Outpre     string
+	Reps       int
+	Seed       int
+	ShufPhenos bool
+	Mode       string
+	Prior      string
+	PriorValue float64
+	PriorTable string
+	TableHead  bool
+	SexPheno   bool
+}
+
+// Run the WARP input exporter on the command line
+func FullWarpInput() {
+	var f WarpInputFlags
+	flag.StringVar(&f.Inpath, "i", "", "input .ped path (default stdin)")
+	flag.StringVar(&f.Outpre, "o", "warp_input", "output prefix")
+	flag.IntVar(&f.Reps, "r", 0, "shuffled replicates to write in addition to the real pedigree")
+	flag.IntVar(&f.Seed, "s", 0, "random seed")
+	flag.BoolVar(&f.ShufPhenos, "p", false, "shuffle phenotype instead of sex")
+	ShufModeFlag(&f.Mode, ShufGeneration)
+	flag.StringVar(&f.Prior, "prior", "uniform", "prior assignment: uniform, founder or table")
+	flag.Float64Var(&f.PriorValue, "pv", 0, "prior for uniform and founder individuals, and for individuals missing from -pt (default: priors sum to 1, or 0 for -pt)")
+	flag.StringVar(&f.PriorTable, "pt", "", "table of individual IDs and priors for -prior table")
+	flag.BoolVar(&f.TableHead, "pth", false, "-pt has a header line")
+	flag.BoolVar(&f.SexPheno, "sexpheno", false, "set every phenotype to the individual's sex")
+	flag.Parse()
+
+	mode := ShufMode(f.Mode)
+	Must(mode.Validate())
+	priorMode := PriorMode(f.Prior)
+	Must(priorMode.Validate())
+	if (priorMode == PriorTable) != (f.PriorTable != "") {
+		log.Fatal(fmt.Errorf("-pt must be given exactly when -prior is table"))
+	}
+	if f.ShufPhenos && f.SexPheno {
+		log.Fatal(fmt.Errorf("-p shuffles phenotypes that -sexpheno replaces; give at most one"))
+	}
+
+	ps, sum, e := ParsePedPathHash(f.Inpath)
+	Must(e)
+	ps = SortedUniqPed(ps...)
+
+	var table map[string]float64
+	if f.PriorTable != "" {
+		table, e = ReadPriorTable(f.PriorTable, f.TableHead)
+		Must(e)
+	}
+	priors, e := AssignPriors(ps, priorMode, f.PriorValue, table)
+	Must(e)
+
+	Must(WriteWarpInputs(WarpInputPathsFor(f.Outpre, "real"), ps, priors, f.SexPheno))
+	if f.Reps < 1 {
+		return
+	}
+	if !f.ShufPhenos {
+		WarnParentSexConflicts(ps, mode)
+	}
+
+	m := ReplicateManifest{
+		Input:       ManifestInput(f.Inpath),
+		InputSHA256: sum,
+		Seed:        uint64(f.Seed),
+		Mode:        f.Mode,
+		ShufPhenos:  f.ShufPhenos,
+	}
+	for i := 0; i < f.Reps; i++ {
+		paths := WarpInputPathsFor(f.Outpre, strconv.Itoa(i))
+		rep := ManifestReplicate{
+			Replicate: i,
+			Seed:      ReplicateSeed(m.Seed, i),
+			Path:      paths.Ped,
+			Output:    paths.Output,
+		}
+		Must(WriteWarpInputs(paths, ShufReplicate(ps, rep.Seed, mode, f.ShufPhenos), priors, f.SexPheno))
+		m.Replicates = append(m.Replicates, rep)
+	}
+	Must(WriteManifestPath(f.Outpre+"_manifest.json", m))
+}
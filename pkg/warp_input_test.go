@@ -0,0 +1,70 @@
+package tdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssignPriors(t *testing.T) {
+	ps := SortedUniqPed(scanExamplePed()...)
+	gens := Generations(BuildPedTree(ps...))
+
+	priors, e := AssignPriors(ps, PriorFounder, 0, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	sum := 0.0
+	for _, p := range ps {
+		prior := priors[p.IndividualID]
+		sum += prior
+		if (gens[p.IndividualID] == 0) != (prior > 0) {
+			t.Errorf("individual %v of generation %v has prior %v", p.IndividualID, gens[p.IndividualID], prior)
+		}
+	}
+	if sum < 0.999999 || sum > 1.000001 {
+		t.Errorf("founder priors sum to %v", sum)
+	}
+
+	priors, e = AssignPriors(ps, PriorTable, 0.1, map[string]float64{ps[0].IndividualID: 0.9})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if priors[ps[0].IndividualID] != 0.9 || priors[ps[1].IndividualID] != 0.1 {
+		t.Errorf("table priors %v", priors)
+	}
+}
+
+func TestReadPriorTable(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		text   string
+		header bool
+		fail   bool
+	}{
+		{"plain", "1\t0.5\n2\t0.25\n", false, false},
+		{"header", "ind\tprior\n1\t0.5\n2\t0.25\n", true, false},
+		{"family", "fam\tind\tprior\nA\t1\t0.5\nA\t2\t0.25\n", true, false},
+		{"unflagged header", "ind\tprior\n1\t0.5\n2\t0.25\n", false, true},
+		{"malformed first row", "1\tx\n2\t0.25\n", false, true},
+	} {
+		path := filepath.Join(dir, tc.name+".txt")
+		if e := os.WriteFile(path, []byte(tc.text), 0644); e != nil {
+			t.Fatal(e)
+		}
+		table, e := ReadPriorTable(path, tc.header)
+		if tc.fail {
+			if e == nil {
+				t.Errorf("%v: read %v instead of failing", tc.name, table)
+			}
+			continue
+		}
+		if e != nil {
+			t.Fatalf("%v: %v", tc.name, e)
+		}
+		if len(table) != 2 || table["1"] != 0.5 || table["2"] != 0.25 {
+			t.Errorf("%v: table %v", tc.name, table)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package tdt
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+
+	"github.com/jgbaldwinbrown/csvh"
+	"golang.org/x/exp/rand"
+)
+
+// The P value of focalID's Y lineage in p, or 1 if it has no testable lineage
+func YLineageP(p *IndexedPed, focalID string, x ExpectedRatio) float64 {
+	i, ok := p.Index[focalID]
+	if !ok {
+		return 1
+	}
+	res := TDTTestRatio(x.For(p, LineageY, focalID), p.LineageFamilies(LineageY, i)...)
+	if math.IsNaN(res.P) {
+		return 1
+	}
+	return res.P
+}
+
+// The smallest P value of any real lineage in one shuffled replicate
+type ReplicateMinP struct {
+	Replicate int
+	MinP      float64
+}
+
+// Running counts for Westfall-Young adjusted P values. Real must be sorted
+// from most to least significant.
+type WestfallYoungAccumulator struct {
+	Real       []TDTResult
+	Expected   ExpectedRatio
+	Replicates int
+	SingleStep []int
+	StepDown   []int
+	MinPs      []ReplicateMinP
+}
+
+func NewWestfallYoungAccumulator(real []TDTResult, x ExpectedRatio) *WestfallYoungAccumulator {
+	return &WestfallYoungAccumulator{
+		Real:       real,
+		Expected:   x,
+		SingleStep: make([]int, len(real)),
+		StepDown:   make([]int, len(real)),
+	}
+}
+
+// Add replicate number i, a pedigree with shuffled sexes. The hypotheses are
+// fixed to the real lineages: each real founder's lineage is retested in the
+// replicate. The real lineage with the jth smallest P value is compared with
+// the smallest replicate P value among real lineages j and up (step-down), and
+// with the smallest replicate P value of all real lineages (single-step).
+func (a *WestfallYoungAccumulator) Add(i int, p *IndexedPed) {
+	q := make([]float64, len(a.Real))
+	minp := 1.0
+	for j := len(a.Real) - 1; j >= 0; j-- {
+		minp = math.Min(minp, YLineageP(p, a.Real[j].Name, a.Expected))
+		q[j] = minp
+	}
+	a.MinPs = append(a.MinPs, ReplicateMinP{Replicate: i, MinP: minp})
+
+	for j, r := range a.Real {
+		if minp <= r.P {
+			a.SingleStep[j]++
+		}
+		if q[j] <= r.P {
+			a.StepDown[j]++
+		}
+	}
+	a.Replicates++
+}
+
+// Add the counts in b to a. Both must have the same real lineages.
+func (a *WestfallYoungAccumulator) Merge(b *WestfallYoungAccumulator) {
+	a.Replicates += b.Replicates
+	for j := range a.Real {
+		a.SingleStep[j] += b.SingleStep[j]
+		a.StepDown[j] += b.StepDown[j]
+	}
+	a.MinPs = append(a.MinPs, b.MinPs...)
+	slices.SortFunc(a.MinPs, func(x, y ReplicateMinP) int {
+		return cmp.Compare(x.Replicate, y.Replicate)
+	})
+}
+
+// A real lineage with family-wise adjusted P values
+type WestfallYoungRecord struct {
+	Name        string
+	P           float64
+	SingleStepP float64
+	StepDownP   float64
+}
+
+// Calculate adjusted P values, with +1 correction. Step-down P values are
+// forced to increase with the raw P values, as Westfall and Young require.
+func (a *WestfallYoungAccumulator) Records() []WestfallYoungRecord {
+	out := make([]WestfallYoungRecord, 0, len(a.Real))
+	denom := float64(a.Replicates + 1)
+	prev := 0.0
+	for j, r := range a.Real {
+		stepDown := math.Max(prev, float64(a.StepDown[j]+1)/denom)
+		prev = stepDown
+		out = append(out, WestfallYoungRecord{
+			Name:        r.Name,
+			P:           r.P,
+			SingleStepP: float64(a.SingleStep[j]+1) / denom,
+			StepDownP:   stepDown,
+		})
+	}
+	return out
+}
+
+// Run the Y lineage TDT test on every male in ped, sorted from most to least significant
+func RealYLineages(p *IndexedPed, x ExpectedRatio) []TDTResult {
+	real := NoZeroes(TDTScan(p, LineageY, x))
+	slices.SortStableFunc(real, func(a, b TDTResult) int {
+		return cmp.Compare(a.P, b.P)
+	})
+	return real
+}
+
+// Calculate Westfall-Young adjusted P values for every Y lineage in ped by
+// shuffling sexes nreps times under mode. Ped should be sorted, as by
+// SortedUniqPed, for the result to be reproducible.
+func WestfallYoung(ped []PedEntry, x ExpectedRatio, mode ShufMode, seed uint64, nreps, nworkers int) *WestfallYoungAccumulator {
+	real := RealYLineages(NewIndexedPed(BuildPedTree(ped...)), x)
+	return RunReplicates(seed, nreps, nworkers,
+		func() *WestfallYoungAccumulator {
+			return NewWestfallYoungAccumulator(real, x)
+		},
+		func(acc *WestfallYoungAccumulator, i int, src rand.Source) {
+			shuf := slices.Clone(ped)
+			ShufPedSexMode(shuf, MathRand(src), mode)
+			acc.Add(i, NewIndexedPed(BuildPedTree(shuf...)))
+		},
+		(*WestfallYoungAccumulator).Merge,
+	)
+}
+
+// Flags for FullWestfallYoung
+type WestfallYoungFlags struct {
+	PedPath  string
+	OutPath  string
+	MinPPath string
+	Reps     int
+	Seed     int
+	Workers  int
+	Expected ExpectedRatio
+	Mode     string
+}
+
+// Run Westfall-Young max-T family-wise correction of the all-male Y TDT scan on the command line
+func FullWestfallYoung() {
+	var f WestfallYoungFlags
+	flag.StringVar(&f.PedPath, "i", "", "path to input .ped file")
+	flag.StringVar(&f.OutPath, "o", "", "path to write output")
+	flag.StringVar(&f.MinPPath, "mo", "", "path to write the minimum P value of each replicate (optional)")
+	flag.IntVar(&f.Reps, "r", 1000, "shuffle replicates")
+	flag.IntVar(&f.Seed, "s", 0, "random seed")
+	ReplicateWorkersFlag(&f.Workers)
+	RegisterExpectedRatioFlags(&f.Expected)
+	ShufModeFlag(&f.Mode, ShufGeneration)
+	flag.Parse()
+	if f.PedPath == "" {
+		log.Fatal(fmt.Errorf("missing -i"))
+	}
+	if f.OutPath == "" {
+		log.Fatal(fmt.Errorf("missing -o"))
+	}
+	Must(f.Expected.Validate())
+	mode := ShufMode(f.Mode)
+	Must(mode.Validate())
+
+	r, e := csvh.OpenMaybeGz(f.PedPath)
+	Must(e)
+	peds, e := ParsePedSafe(r)
+	r.Close()
+	Must(e)
+	peds = SortedUniqPed(peds...)
+	WarnParentSexConflicts(peds, mode)
+
+	acc := WestfallYoung(peds, f.Expected, mode, uint64(f.Seed), f.Reps, f.Workers)
+
+	Must(WriteJsonPath(f.OutPath, acc.Records()))
+	if f.MinPPath != "" {
+		Must(WriteJsonPath(f.MinPPath, acc.MinPs))
+	}
+}
@@ -0,0 +1,102 @@
+package tdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+// scanExamplePed with the offspring of founder 1 and of founder 3 given new sexes
+func westfallYoungReplicate(sexes1, sexes3 [4]int64) *IndexedPed {
+	ped := scanExamplePed()
+	for i := range 4 {
+		ped[4+i].Sex = sexes1[i]
+		ped[8+i].Sex = sexes3[i]
+	}
+	return NewIndexedPed(BuildPedTree(ped...))
+}
+
+func TestWestfallYoungAdd(t *testing.T) {
+	x := ExpectedRatio{MaleProb: 0.5}
+	real := RealYLineages(NewIndexedPed(BuildPedTree(scanExamplePed()...)), x)
+	if len(real) != 2 || real[0].Name != "1" || real[1].Name != "3" {
+		t.Fatalf("real lineages %v; expected 1 (4 sons) then 3 (1 son, 3 daughters)", real)
+	}
+
+	acc := NewWestfallYoungAccumulator(real, x)
+	// As extreme as the real pedigree in both lineages
+	acc.Add(0, westfallYoungReplicate([4]int64{1, 1, 1, 1}, [4]int64{2, 1, 2, 2}))
+	// Lineage 1 balanced, lineage 3 as extreme as in the real pedigree
+	acc.Add(1, westfallYoungReplicate([4]int64{1, 1, 2, 2}, [4]int64{1, 1, 2, 1}))
+	// Lineage 1 as extreme as in the real pedigree, lineage 3 balanced
+	acc.Add(2, westfallYoungReplicate([4]int64{2, 2, 2, 2}, [4]int64{1, 1, 2, 2}))
+
+	// Single-step compares each lineage with the overall minimum (P(4 vs 0),
+	// P(1 vs 3), P(4 vs 0)); step-down compares lineage 1 with the minimum
+	// of both and lineage 3 with its own (P(1 vs 3), P(1 vs 3), 1)
+	if !reflect.DeepEqual(acc.SingleStep, []int{2, 3}) {
+		t.Errorf("single-step counts %v", acc.SingleStep)
+	}
+	if !reflect.DeepEqual(acc.StepDown, []int{2, 2}) {
+		t.Errorf("step-down counts %v", acc.StepDown)
+	}
+	recs := acc.Records()
+	expect := []WestfallYoungRecord{
+		{Name: "1", P: real[0].P, SingleStepP: 0.75, StepDownP: 0.75},
+		{Name: "3", P: real[1].P, SingleStepP: 1, StepDownP: 0.75},
+	}
+	if !reflect.DeepEqual(recs, expect) {
+		t.Errorf("records %v != expect %v", recs, expect)
+	}
+}
+
+func TestWestfallYoungMerge(t *testing.T) {
+	real := []TDTResult{{Name: "a", P: 0.01}, {Name: "b", P: 0.02}, {Name: "c", P: 0.5}}
+	a := NewWestfallYoungAccumulator(real, ExpectedRatio{MaleProb: 0.5})
+	a.Replicates = 2
+	a.SingleStep = []int{1, 1, 2}
+	a.StepDown = []int{1, 0, 2}
+	a.MinPs = []ReplicateMinP{{3, 0.1}, {0, 0.2}}
+	b := NewWestfallYoungAccumulator(real, ExpectedRatio{MaleProb: 0.5})
+	b.Replicates = 2
+	b.SingleStep = []int{1, 2, 2}
+	b.StepDown = []int{1, 1, 2}
+	b.MinPs = []ReplicateMinP{{2, 0.3}, {1, 0.4}}
+	a.Merge(b)
+
+	if a.Replicates != 4 {
+		t.Errorf("replicates %v", a.Replicates)
+	}
+	expectMinPs := []ReplicateMinP{{0, 0.2}, {1, 0.4}, {2, 0.3}, {3, 0.1}}
+	if !reflect.DeepEqual(a.MinPs, expectMinPs) {
+		t.Errorf("minps %v != expect %v", a.MinPs, expectMinPs)
+	}
+
+	// Raw step-down P values are 3/5, 2/5, 5/5; the second is raised to the first
+	recs := a.Records()
+	expectSingle := []float64{3.0 / 5.0, 4.0 / 5.0, 1}
+	expectStepDown := []float64{3.0 / 5.0, 3.0 / 5.0, 1}
+	for j, r := range recs {
+		if r.SingleStepP != expectSingle[j] || r.StepDownP != expectStepDown[j] {
+			t.Errorf("record %v: %#v; expected single-step %v, step-down %v", j, r, expectSingle[j], expectStepDown[j])
+		}
+		if j > 0 && r.StepDownP < recs[j-1].StepDownP {
+			t.Errorf("step-down P decreases at %v: %v < %v", j, r.StepDownP, recs[j-1].StepDownP)
+		}
+	}
+}
+
+func TestWestfallYoungWorkers(t *testing.T) {
+	ped := SortedUniqPed(scanExamplePed()...)
+	x := ExpectedRatio{MaleProb: 0.5}
+	one := WestfallYoung(ped, x, ShufSibship, 3, 50, 1)
+	four := WestfallYoung(ped, x, ShufSibship, 3, 50, 4)
+	if !reflect.DeepEqual(one.Records(), four.Records()) {
+		t.Errorf("records with 1 worker %v != 4 workers %v", one.Records(), four.Records())
+	}
+	if !reflect.DeepEqual(one.MinPs, four.MinPs) {
+		t.Errorf("minps with 1 worker %v != 4 workers %v", one.MinPs, four.MinPs)
+	}
+	if one.Replicates != 50 {
+		t.Errorf("replicates %v", one.Replicates)
+	}
+}
@@ -1,10 +1,18 @@
 package tdt
 
 import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
 	"github.com/montanaflynn/stats"
+	"gonum.org/v1/gonum/stat/distuv"
 )
 
-// Convert data into Z scores by subtracting the mean and dividing by the standard deviation
+// Convert data into Z scores by subtracting the mean and dividing by the
+// standard deviation. If all values are equal, every Z score is 0.
 func Zscores(fs stats.Float64Data) ([]float64, error) {
 	mean, e := stats.Mean(fs)
 	if e != nil {
@@ -14,9 +22,158 @@ func Zscores(fs stats.Float64Data) ([]float64, error) {
 	if e != nil {
 		return nil, e
 	}
+	if slices.Min(fs) == slices.Max(fs) {
+		// avoid rounding error in the mean giving a tiny nonzero sd
+		sd = 0
+	}
 	out := make([]float64, 0, len(fs))
 	for _, f := range fs {
-		out = append(out, (f-mean)/sd)
+		out = append(out, standardize(f, mean, sd))
+	}
+	return out, nil
+}
+
+func standardize(f, center, scale float64) float64 {
+	if scale == 0 {
+		return 0
+	}
+	return (f - center) / scale
+}
+
+// Scale factor that makes the MAD estimate the standard deviation of normal data
+const madScale = 1.482602218505602
+
+// Scale factor that makes the mean absolute deviation estimate the standard deviation of normal data
+const meanADScale = 1.2533141373155001
+
+// Convert data into robust Z scores by subtracting the median and dividing by
+// the scaled median absolute deviation. When more than half the values are
+// equal, the MAD is 0 and the scaled mean absolute deviation is used instead;
+// if all values are equal, every score is 0.
+func RobustZscores(fs stats.Float64Data) ([]float64, error) {
+	med, e := stats.Median(fs)
+	if e != nil {
+		return nil, e
+	}
+	devs := make([]float64, 0, len(fs))
+	for _, f := range fs {
+		devs = append(devs, math.Abs(f-med))
+	}
+	mad, e := stats.Median(devs)
+	if e != nil {
+		return nil, e
+	}
+	scale := madScale * mad
+	if scale == 0 {
+		meanAD, e := stats.Mean(devs)
+		if e != nil {
+			return nil, e
+		}
+		scale = meanADScale * meanAD
+	}
+	out := make([]float64, 0, len(fs))
+	for _, f := range fs {
+		out = append(out, standardize(f, med, scale))
+	}
+	return out, nil
+}
+
+// Ranks of the values, starting at 1, with ties given their mean rank
+func midRanks(fs []float64) []float64 {
+	order := make([]int, len(fs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return fs[order[a]] < fs[order[b]]
+	})
+	out := make([]float64, len(fs))
+	for i := 0; i < len(order); {
+		j := i + 1
+		for j < len(order) && fs[order[j]] == fs[order[i]] {
+			j++
+		}
+		for _, k := range order[i:j] {
+			out[k] = float64(i+j+1) / 2
+		}
+		i = j
+	}
+	return out
+}
+
+// Convert data into normal quantiles of their ranks, using Blom's offset
+// (rank - 3/8) / (n + 1/4). Tied values get the quantile of their mean rank.
+func RankInverseNormal(fs stats.Float64Data) ([]float64, error) {
+	n := len(fs)
+	if n < 1 {
+		return nil, stats.EmptyInputErr
+	}
+	out := midRanks(fs)
+	for i, rank := range out {
+		out[i] = distuv.UnitNormal.Quantile((rank - 0.375) / (float64(n) + 0.25))
 	}
 	return out, nil
 }
+
+// Keep logits finite for probabilities of exactly 0 or 1
+const logitEps = 1e-9
+
+// Convert probabilities such as posteriors into Z scores of their logits.
+// Probabilities are clamped to [1e-9, 1-1e-9]; values outside [0, 1] are an error.
+func LogitZscores(fs stats.Float64Data) ([]float64, error) {
+	logits := make([]float64, 0, len(fs))
+	for _, f := range fs {
+		if !(f >= 0 && f <= 1) {
+			return nil, fmt.Errorf("LogitZscores: %v is not a probability", f)
+		}
+		p := min(max(f, logitEps), 1-logitEps)
+		logits = append(logits, math.Log(p/(1-p)))
+	}
+	return Zscores(logits)
+}
+
+// How scores are normalized within a pedigree before comparing pedigrees
+type Normalization string
+
+const (
+	NormZ      Normalization = "z"      // mean and standard deviation
+	NormRobust Normalization = "robust" // median and median absolute deviation
+	NormRank   Normalization = "rank"   // rank-based inverse normal transform
+	NormLogit  Normalization = "logit"  // mean and standard deviation of the logits, for probabilities
+)
+
+func (n Normalization) Validate() error {
+	switch n {
+	case NormZ, NormRobust, NormRank, NormLogit:
+		return nil
+	}
+	return fmt.Errorf("unknown normalization %q; choose z, robust, rank or logit", string(n))
+}
+
+// Normalize data with the chosen normalization. All normalizations preserve
+// the order of the data, so the largest value has the largest normalized value.
+func Normalize(fs stats.Float64Data, norm Normalization) ([]float64, error) {
+	switch norm {
+	case NormZ:
+		return Zscores(fs)
+	case NormRobust:
+		return RobustZscores(fs)
+	case NormRank:
+		return RankInverseNormal(fs)
+	case NormLogit:
+		return LogitZscores(fs)
+	}
+	return nil, norm.Validate()
+}
+
+// The n largest normalized values, largest first
+func topNormalized(fs []float64, norm Normalization, n int) ([]float64, error) {
+	normed, e := Normalize(fs, norm)
+	if e != nil {
+		return nil, e
+	}
+	slices.SortFunc(normed, func(a, b float64) int {
+		return cmp.Compare(b, a)
+	})
+	return normed[:min(n, len(normed))], nil
+}
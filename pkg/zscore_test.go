@@ -0,0 +1,58 @@
+package tdt
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	fs := []float64{0.1, 0.2, 0.2, 0.3, 0.9}
+
+	robust, e := Normalize(fs, NormRobust)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// median 0.2, MAD 0.1
+	if want := 0.7 / (madScale * 0.1); math.Abs(robust[4]-want) > 1e-9 {
+		t.Errorf("robust z of outlier %v != %v", robust[4], want)
+	}
+
+	rank, e := Normalize(fs, NormRank)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if rank[1] != rank[2] || rank[0] != -rank[4] || !(rank[2] < 0 && rank[3] > 0) {
+		t.Errorf("rank inverse normal %v", rank)
+	}
+
+	if _, e := Normalize([]float64{0.5, 1.5}, NormLogit); e == nil {
+		t.Errorf("logit of 1.5 did not fail")
+	}
+
+	for _, norm := range []Normalization{NormZ, NormRobust, NormRank, NormLogit} {
+		zs, e := Normalize([]float64{0.4, 0.4, 0.4}, norm)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !slices.Equal(zs, []float64{0, 0, 0}) {
+			t.Errorf("%v of equal values: %v", norm, zs)
+		}
+
+		ents := []Entry{}
+		for _, f := range fs {
+			ents = append(ents, Entry{Posterior: f})
+		}
+		normed, e := GetNormalizedBy(ents, PosteriorScore, norm)
+		if e != nil {
+			t.Fatal(e)
+		}
+		top, e := topNormalized(fs, norm, 2)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if top[0] != slices.Max(normed) || top[0] != normed[4] || top[1] != normed[3] {
+			t.Errorf("%v: top %v of %v", norm, top, normed)
+		}
+	}
+}